@@ -0,0 +1,115 @@
+package iorest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+type batchRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+type batchKey struct{}
+
+const defaultBatchSize = 20
+
+type batchResult struct {
+	Status int         `json:"status"`
+	Body   interface{} `json:"body,omitempty"`
+}
+
+// HandleBatch serves a JSON array of sub-requests on resource, at most
+// MaxBatchSize of them. With ?atomic the batch is fail-fast on a best-effort
+// basis: once a sub-request fails the rest are answered with 424 and not
+// run, but sub-requests that already succeeded are not rolled back.
+func (s *Server) HandleBatch(resource string) {
+	s.HandleMethod("POST", resource, func(c *Context) (interface{}, error) {
+		return s.batch(c, resource)
+	})
+}
+
+func (s *Server) batch(c *Context, resource string) (interface{}, error) {
+	if c.request.Context().Value(batchKey{}) != nil {
+		return nil, requestError(Errorf(http.StatusBadRequest, "Nested batch requests are not allowed"))
+	}
+	var reqs []batchRequest
+	if err := c.ParseJson(&reqs); err != nil {
+		return nil, err
+	}
+	limit := s.MaxBatchSize
+	if limit <= 0 {
+		limit = defaultBatchSize
+	}
+	if len(reqs) > limit {
+		return nil, requestError(Errorf(http.StatusRequestEntityTooLarge, "Batch exceeds %d requests", limit))
+	}
+	_, atomic := c.request.URL.Query()["atomic"]
+	results := make([]batchResult, len(reqs))
+	failed := false
+	for i, req := range reqs {
+		if failed {
+			results[i] = batchResult{Status: http.StatusFailedDependency}
+			continue
+		}
+		results[i] = s.dispatch(c, resource, req)
+		failed = atomic && s.batchFailed(results[i])
+	}
+	return results, nil
+}
+
+func (s *Server) dispatch(c *Context, resource string, req batchRequest) batchResult {
+	path := req.Path
+	if !strings.HasPrefix(path, s.Prefix) {
+		path = s.Prefix + strings.TrimPrefix(path, "/")
+	}
+	sub, err := http.NewRequestWithContext(context.WithValue(c.Context(), batchKey{}, resource), strings.ToUpper(req.Method), path, bytes.NewReader(req.Body))
+	if err != nil {
+		return batchResult{Status: http.StatusBadRequest, Body: s.errorBody(Errorf(http.StatusBadRequest, "%s", err.Error()))}
+	}
+	sub.RemoteAddr = c.request.RemoteAddr
+	sub.Host = c.request.Host
+	sub.TLS = c.request.TLS
+	for k, v := range c.request.Header {
+		if k != "Content-Length" && k != "Content-Type" && k != "Accept-Encoding" {
+			sub.Header[k] = v
+		}
+	}
+	if len(req.Body) > 0 {
+		sub.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, sub)
+	res := batchResult{Status: rec.Code}
+	if isJSONType(rec.Header().Get("Content-Type")) {
+		res.Body = json.RawMessage(bytes.TrimSpace(rec.Body.Bytes()))
+	} else if rec.Body.Len() > 0 {
+		res.Body = rec.Body.String()
+	}
+	return res
+}
+
+func (s *Server) batchFailed(res batchResult) bool {
+	if res.Status >= 400 {
+		return true
+	}
+	raw, ok := res.Body.(json.RawMessage)
+	if !ok {
+		return false
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(raw, &fields) != nil {
+		return false
+	}
+	key := s.ErrorFieldNames.Code
+	if key == "" {
+		key = "error"
+	}
+	_, ok = fields[key]
+	return ok
+}
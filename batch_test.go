@@ -0,0 +1,85 @@
+package iorest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newBatchServer() *Server {
+	s := newTestServer()
+	s.HandleBatch("batch")
+	users := []string{"ann"}
+	s.HandleMethod("GET", "users", func(c *Context) (interface{}, error) {
+		return users, nil
+	})
+	s.HandleMethod("POST", "users", func(c *Context) (interface{}, error) {
+		var u struct{ Name string }
+		if err := c.ParseJson(&u); err != nil {
+			return nil, err
+		}
+		users = append(users, u.Name)
+		c.SetResponseCode(http.StatusCreated)
+		return u, nil
+	})
+	return s
+}
+
+func serveBatch(t *testing.T, s *Server, path, body string) []batchResult {
+	t.Helper()
+	res, _ := s.ServeTest("POST", path, strings.NewReader(body))
+	if res.Code != http.StatusOK {
+		t.Fatalf("batch: got %d %q", res.Code, res.Body)
+	}
+	var results []batchResult
+	if err := json.Unmarshal(res.Body, &results); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestBatch(t *testing.T) {
+	s := newBatchServer()
+	results := serveBatch(t, s, "/api/batch", `[
+		{"method":"POST","path":"/api/users","body":{"Name":"bob"}},
+		{"method":"get","path":"users"}
+	]`)
+	if len(results) != 2 || results[0].Status != http.StatusCreated || results[1].Status != http.StatusOK {
+		t.Fatalf("got %+v", results)
+	}
+	if got, _ := json.Marshal(results[1].Body); string(got) != `["ann","bob"]` {
+		t.Errorf("GET after POST = %s", got)
+	}
+	results = serveBatch(t, s, "/api/batch?atomic", `[
+		{"method":"GET","path":"/api/missing"},
+		{"method":"GET","path":"/api/users"}
+	]`)
+	if results[0].Status != http.StatusNotFound || results[1].Status != http.StatusFailedDependency {
+		t.Errorf("atomic: got %+v", results)
+	}
+	s.MaxBatchSize = 1
+	res, _ := s.ServeTest("POST", "/api/batch", strings.NewReader(`[{"path":"users"},{"path":"users"}]`))
+	if res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized batch: got %d", res.Code)
+	}
+	s.MaxBatchSize = -1
+	if results := serveBatch(t, s, "/api/batch", `[{"method":"GET","path":"/api/users"}]`); len(results) != 1 || results[0].Status != http.StatusOK {
+		t.Errorf("negative MaxBatchSize: got %+v", results)
+	}
+}
+
+func TestBatchNested(t *testing.T) {
+	s := newBatchServer()
+	s.CaseInsensitiveRoutes = true
+	s.CleanPath = true
+	s.HandleBatch("bulk")
+	for _, path := range []string{"/api/batch", "batch", "/api/Batch", "/api//batch", "/api/bulk"} {
+		inner := `[{"method":"GET","path":"/api/users"}]`
+		body := `[{"method":"POST","path":"` + path + `","body":` + inner + `}]`
+		results := serveBatch(t, s, "/api/batch", body)
+		if len(results) != 1 || results[0].Status != http.StatusBadRequest {
+			t.Errorf("%s: nested batch got %+v, want 400", path, results)
+		}
+	}
+}
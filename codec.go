@@ -0,0 +1,76 @@
+package iorest
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type ProtoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(ProtoMessage)
+	if !ok {
+		return nil, fmt.Errorf("Resource is not proto message.")
+	}
+	return m.Marshal()
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(ProtoMessage)
+	if !ok {
+		return fmt.Errorf("Target is not proto message.")
+	}
+	return m.Unmarshal(data)
+}
+
+var defaultCodecs = map[string]Codec{
+	"application/x-protobuf": protoCodec{},
+}
+
+func (s *Server) RegisterCodec(contentType string, codec Codec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.codecs == nil {
+		s.codecs = make(map[string]Codec)
+	}
+	s.codecs[contentType] = codec
+}
+
+func (s *Server) codec(contentType string) Codec {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if codec, ok := s.codecs[contentType]; ok {
+		return codec
+	}
+	return defaultCodecs[contentType]
+}
+
+func (c *Context) parseCodec(contentType string, v interface{}) error {
+	codec := c.server.codec(contentType)
+	if codec == nil {
+		return fmt.Errorf("No codec for '%s'", contentType)
+	}
+	data, err := ioutil.ReadAll(c.request.Body)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, v)
+}
+
+func (c *Context) ParseProto(msg ProtoMessage) error {
+	return c.parseCodec("application/x-protobuf", msg)
+}
+
+func (c *Context) ParseMsgpack(v interface{}) error {
+	return c.parseCodec("application/msgpack", v)
+}
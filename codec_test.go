@@ -0,0 +1,93 @@
+package iorest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeProto struct {
+	Name string
+}
+
+func (m *fakeProto) Marshal() ([]byte, error) {
+	return []byte("proto:" + m.Name), nil
+}
+
+func (m *fakeProto) Unmarshal(data []byte) error {
+	m.Name = string(bytes.TrimPrefix(data, []byte("proto:")))
+	return nil
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("greet", func(c *Context) (interface{}, error) {
+		var in fakeProto
+		if err := c.ParseProto(&in); err != nil {
+			return nil, err
+		}
+		c.SetResourceType("application/x-protobuf")
+		return &fakeProto{Name: "hello " + in.Name}, nil
+	})
+	r := httptest.NewRequest("POST", "/api/greet", bytes.NewReader([]byte("proto:bob")))
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	w := httptest.NewRecorder()
+	s.serveHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-protobuf" {
+		t.Fatalf("Got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var out fakeProto
+	if err := out.Unmarshal(w.Body.Bytes()); err != nil || out.Name != "hello bob" {
+		t.Errorf("Round trip gave %q", w.Body.String())
+	}
+}
+
+type fakeMsgpack struct {
+	marshaled, unmarshaled int
+}
+
+func (f *fakeMsgpack) Marshal(v interface{}) ([]byte, error) {
+	f.marshaled++
+	data, err := json.Marshal(v)
+	return append([]byte("msgpack:"), data...), err
+}
+
+func (f *fakeMsgpack) Unmarshal(data []byte, v interface{}) error {
+	f.unmarshaled++
+	return json.Unmarshal(bytes.TrimPrefix(data, []byte("msgpack:")), v)
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	s := newTestServer()
+	codec := &fakeMsgpack{}
+	s.RegisterCodec("application/msgpack", codec)
+	s.HandleFunc("items", func(c *Context) (interface{}, error) {
+		var in map[string]int
+		if c.Method() == "POST" {
+			if err := c.ParseMsgpack(&in); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]int{"count": in["count"] + 1}, nil
+	})
+	r := httptest.NewRequest("POST", "/api/items", strings.NewReader(`msgpack:{"count":2}`))
+	r.Header.Set("Accept", "application/msgpack")
+	w := httptest.NewRecorder()
+	s.serveHTTP(w, r)
+	if got := w.Body.String(); got != `msgpack:{"count":3}` || w.Header().Get("Content-Type") != "application/msgpack" {
+		t.Errorf("Negotiated msgpack response %q (%s)", got, w.Header().Get("Content-Type"))
+	}
+	if codec.marshaled != 1 || codec.unmarshaled != 1 {
+		t.Errorf("Codec used %d/%d times", codec.marshaled, codec.unmarshaled)
+	}
+	res, err := s.ServeTest("GET", "/api/items", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Header.Get("Content-Type") != "application/json" || codec.marshaled != 1 {
+		t.Errorf("Request without Accept was not served as JSON: %s", res.Header.Get("Content-Type"))
+	}
+}
@@ -0,0 +1,138 @@
+package iorest
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+type gzipWriter struct {
+	http.ResponseWriter
+	threshold func(contentType string) int
+	limit     int
+	sized     bool
+	status    int
+	buf       []byte
+	gz        *gzip.Writer
+	decided   bool
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.decided || code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if !w.decided && !w.sized {
+		w.sized = true
+		w.limit = -1
+		if w.Header().Get("Content-Encoding") == "" {
+			w.limit = w.threshold(w.Header().Get("Content-Type"))
+		}
+		if w.limit < 0 {
+			if err := w.flush(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.limit {
+		if err := w.flush(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *gzipWriter) flush(compress bool) error {
+	w.decided = true
+	if compress {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	buf := w.buf
+	w.buf = nil
+	if compress {
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+		_, err := w.gz.Write(buf)
+		return err
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *gzipWriter) FlushError() error {
+	if !w.decided {
+		if err := w.flush(false); err != nil {
+			return err
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *gzipWriter) Close() error {
+	if !w.decided {
+		return w.flush(false)
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+func (s *Server) compressionThreshold(contentType string) int {
+	media, _, _ := mime.ParseMediaType(contentType)
+	if v, ok := s.CompressionThresholds[media]; ok {
+		if v <= 0 {
+			return -1
+		}
+		return v
+	}
+	if s.CompressionThreshold > 0 {
+		return s.CompressionThreshold
+	}
+	return -1
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "gzip") {
+			continue
+		}
+		for _, param := range params[1:] {
+			if strings.ReplaceAll(strings.TrimSpace(param), " ", "") == "q=0" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
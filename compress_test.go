@@ -0,0 +1,57 @@
+package iorest
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompressionThresholds(t *testing.T) {
+	s := newTestServer()
+	s.CompressionThreshold = 1024
+	s.CompressionThresholds = map[string]int{"text/event-stream": 0, "text/csv": 64}
+	big := strings.Repeat("a", 4096)
+	s.HandleFunc("text", func(c *Context) (interface{}, error) {
+		c.SetResourceType(c.FormValue("type", "text/plain"))
+		return big[:c.FormInt("n", 0)], nil
+	})
+	tests := []struct {
+		query      string
+		compressed bool
+	}{
+		{"n=10", false},
+		{"n=1023", false},
+		{"n=4096", true},
+		{"n=100&type=text/csv", true},
+		{"n=10&type=text/csv", false},
+		{"n=4096&type=text/event-stream", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/text?"+tt.query, nil)
+		n, _ := strconv.Atoi(req.URL.Query().Get("n"))
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, req)
+		compressed := w.Header().Get("Content-Encoding") == "gzip"
+		if compressed != tt.compressed {
+			t.Errorf("%s: compressed = %v, want %v", tt.query, compressed, tt.compressed)
+			continue
+		}
+		body := w.Body.String()
+		if compressed {
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(zr)
+			body = string(data)
+		}
+		if body != big[:n] || w.Code != http.StatusOK {
+			t.Errorf("%s: got %d with %d bytes, want %d", tt.query, w.Code, len(body), n)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package iorest
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+type CSRFOptions struct {
+	CookieName string
+	HeaderName string
+	Path       string
+	Secure     bool
+	MaxAge     int
+}
+
+func CSRF(opts CSRFOptions) Middleware {
+	if opts.CookieName == "" {
+		opts.CookieName = "csrf_token"
+	}
+	if opts.HeaderName == "" {
+		opts.HeaderName = "X-CSRF-Token"
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	return func(next Handler) Handler {
+		return func(c *Context) (interface{}, error) {
+			token := c.Cookie(opts.CookieName)
+			switch c.Method() {
+			case "GET", "HEAD", "OPTIONS":
+				if token == "" {
+					token = randomHex(32)
+					c.SetCookie(&http.Cookie{
+						Name:     opts.CookieName,
+						Value:    token,
+						Path:     opts.Path,
+						MaxAge:   opts.MaxAge,
+						Secure:   opts.Secure,
+						SameSite: http.SameSiteLaxMode,
+					})
+				}
+			default:
+				header := c.Header(opts.HeaderName)
+				if token == "" || subtle.ConstantTimeCompare([]byte(header), []byte(token)) != 1 {
+					c.SetErrorResponseCode(http.StatusForbidden)
+					return nil, Errorf(http.StatusForbidden, "CSRF token mismatch")
+				}
+			}
+			c.Set("csrf_token", token)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package iorest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	s := newTestServer()
+	s.Use(CSRF(CSRFOptions{}))
+	s.HandleFunc("form", func(c *Context) (interface{}, error) {
+		return c.Get("csrf_token"), nil
+	})
+	w := httptest.NewRecorder()
+	s.serveHTTP(w, httptest.NewRequest("GET", "/api/form", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "csrf_token" || len(cookies[0].Value) != 64 {
+		t.Fatalf("GET did not issue a token cookie: %v", cookies)
+	}
+	token := cookies[0].Value
+	other := token[:63] + "0"
+	if other == token {
+		other = token[:63] + "1"
+	}
+	tests := []struct {
+		name   string
+		header string
+		code   int
+	}{
+		{"valid", token, http.StatusOK},
+		{"missing", "", http.StatusForbidden},
+		{"mismatched", other, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/api/form", nil)
+		r.AddCookie(&http.Cookie{Name: "csrf_token", Value: token})
+		if tt.header != "" {
+			r.Header.Set("X-CSRF-Token", tt.header)
+		}
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s token: got %d, want %d", tt.name, w.Code, tt.code)
+		}
+	}
+	r := httptest.NewRequest("DELETE", "/api/form", nil)
+	r.Header.Set("X-CSRF-Token", token)
+	w = httptest.NewRecorder()
+	s.serveHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Header without cookie gave %d, want 403", w.Code)
+	}
+}
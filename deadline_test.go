@@ -0,0 +1,92 @@
+package iorest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestSetWriteDeadline(t *testing.T) {
+	s := newTestServer()
+	deadlineErr := make(chan error, 1)
+	s.HandleFunc("download", func(c *Context) (interface{}, error) {
+		deadlineErr <- c.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+		c.SetResourceType("application/octet-stream")
+		return io.LimitReader(zeroReader{}, 1<<30), nil
+	})
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		s.Mux.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /api/download HTTP/1.1\r\nHost: test\r\n\r\n")
+	if err := <-deadlineErr; err != nil {
+		t.Fatalf("SetWriteDeadline: %s", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still writing to a client that stopped reading")
+	}
+	c := &Context{writer: httptest.NewRecorder()}
+	if err := c.SetWriteDeadline(time.Now()); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("unsupported writer: got %v, want ErrNotSupported", err)
+	}
+}
+
+func TestSetReadDeadline(t *testing.T) {
+	s := newTestServer()
+	type result struct {
+		n   int64
+		err error
+	}
+	results := make(chan result, 1)
+	s.HandleFunc("upload", func(c *Context) (interface{}, error) {
+		if err := c.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+			return nil, err
+		}
+		n, err := io.Copy(io.Discard, c.request.Body)
+		results <- result{n, err}
+		return n, err
+	})
+	ts := httptest.NewServer(s.Mux)
+	defer ts.Close()
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "POST /api/upload HTTP/1.1\r\nHost: test\r\nContent-Type: application/octet-stream\r\nContent-Length: 1000\r\n\r\nabc")
+	select {
+	case res := <-results:
+		if res.n != 3 || !errors.Is(res.err, os.ErrDeadlineExceeded) {
+			t.Errorf("read %d bytes with %v, want 3 bytes and a deadline error", res.n, res.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still blocked on a stalled upload")
+	}
+	c := &Context{writer: httptest.NewRecorder()}
+	if err := c.SetReadDeadline(time.Now()); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("unsupported writer: got %v, want ErrNotSupported", err)
+	}
+}
@@ -0,0 +1,91 @@
+package iorest
+
+import (
+	"sort"
+	"strings"
+)
+
+type RouteDoc struct {
+	Summary     string      `json:"summary,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Tags        []string    `json:"tags,omitempty"`
+	Example     interface{} `json:"example,omitempty"`
+}
+
+type RouteInfo struct {
+	Resource string   `json:"resource"`
+	Methods  []string `json:"methods"`
+	Doc      RouteDoc `json:"doc"`
+}
+
+func (s *Server) HandleFuncDoc(resource string, handler Handler, doc RouteDoc) {
+	s.HandleFunc(resource, handler)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.docs == nil {
+		s.docs = make(map[string]RouteDoc)
+	}
+	s.docs[resource] = doc
+}
+
+func (s *Server) Routes() []RouteInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := make(map[string]bool)
+	for resource, handler := range s.handlers {
+		if handler != nil {
+			seen[resource] = true
+		}
+	}
+	for resource := range s.methods {
+		seen[resource] = true
+	}
+	routes := make([]RouteInfo, 0, len(seen))
+	for resource := range seen {
+		methods := strings.Split(s.allowedMethods(resource), ", ")
+		routes = append(routes, RouteInfo{Resource: resource, Methods: methods, Doc: s.docs[resource]})
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Resource < routes[j].Resource })
+	return routes
+}
+
+func (s *Server) OpenAPI() map[string]interface{} {
+	paths := make(map[string]interface{})
+	for _, route := range s.Routes() {
+		ops := make(map[string]interface{})
+		for _, method := range route.Methods {
+			if method == "OPTIONS" {
+				continue
+			}
+			op := map[string]interface{}{
+				"responses": map[string]interface{}{"200": okResponse(route.Doc.Example)},
+			}
+			if route.Doc.Summary != "" {
+				op["summary"] = route.Doc.Summary
+			}
+			if route.Doc.Description != "" {
+				op["description"] = route.Doc.Description
+			}
+			if len(route.Doc.Tags) > 0 {
+				op["tags"] = route.Doc.Tags
+			}
+			ops[strings.ToLower(method)] = op
+		}
+		paths[s.Prefix+route.Resource] = ops
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": "API", "version": "1.0.0"},
+		"paths":   paths,
+	}
+}
+
+func okResponse(example interface{}) map[string]interface{} {
+	res := map[string]interface{}{"description": "OK"}
+	if example != nil {
+		res["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"example": example},
+		}
+	}
+	return res
+}
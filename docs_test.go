@@ -0,0 +1,53 @@
+package iorest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRouteDocs(t *testing.T) {
+	s := newTestServer()
+	noop := func(c *Context) (interface{}, error) { return nil, nil }
+	doc := RouteDoc{Summary: "List users", Description: "Returns all users.", Tags: []string{"users"}, Example: []string{"ann"}}
+	s.HandleFuncDoc("users", noop, doc)
+	s.HandleMethod("GET", "health", noop)
+	routes := s.Routes()
+	want := []RouteInfo{
+		{Resource: "health", Methods: []string{"GET", "OPTIONS"}},
+		{Resource: "users", Methods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}, Doc: doc},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("Routes() = %+v, want %+v", routes, want)
+	}
+	data, err := json.Marshal(s.OpenAPI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Summary     string
+			Description string
+			Tags        []string
+			Responses   map[string]struct {
+				Content map[string]struct{ Example interface{} }
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	get, ok := spec.Paths["/api/users"]["get"]
+	if !ok || get.Summary != doc.Summary || get.Description != doc.Description || !reflect.DeepEqual(get.Tags, doc.Tags) {
+		t.Fatalf("users GET operation = %+v", get)
+	}
+	if ex := get.Responses["200"].Content["application/json"].Example; !reflect.DeepEqual(ex, []interface{}{"ann"}) {
+		t.Errorf("example = %v", ex)
+	}
+	if _, ok := spec.Paths["/api/users"]["options"]; ok {
+		t.Error("OPTIONS documented as an operation")
+	}
+	if _, ok := spec.Paths["/api/health"]["get"]; !ok {
+		t.Error("undocumented route missing from spec")
+	}
+}
@@ -0,0 +1,47 @@
+package iorest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+func ETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func (c *Context) SetETag(tag string) {
+	c.SetHeader("ETag", tag)
+}
+
+func (c *Context) IfMatch() []string {
+	var tags []string
+	for _, v := range c.request.Header.Values("If-Match") {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+func (c *Context) CheckIfMatch(current string) bool {
+	tags := c.IfMatch()
+	if len(tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if tag == "*" || (tag == current && !strings.HasPrefix(tag, "W/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Context) PreconditionFailed() error {
+	c.SetErrorResponseCode(http.StatusPreconditionFailed)
+	return Errorf(http.StatusPreconditionFailed, "Precondition failed")
+}
@@ -0,0 +1,46 @@
+package iorest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	doc := []byte(`{"v":1}`)
+	current := ETag(doc)
+	s := newTestServer()
+	s.HandleFunc("doc", func(c *Context) (interface{}, error) {
+		if !c.CheckIfMatch(current) {
+			return nil, c.PreconditionFailed()
+		}
+		c.SetETag(ETag([]byte(`{"v":2}`)))
+		return "updated", nil
+	})
+	tests := []struct {
+		ifMatch string
+		code    int
+	}{
+		{"", http.StatusOK},
+		{current, http.StatusOK},
+		{`"stale", ` + current, http.StatusOK},
+		{"*", http.StatusOK},
+		{`"stale"`, http.StatusPreconditionFailed},
+		{"W/" + current, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("PUT", "/api/doc", strings.NewReader(`{"v":2}`))
+		if tt.ifMatch != "" {
+			req.Header.Set("If-Match", tt.ifMatch)
+		}
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("If-Match %q: got %d, want %d", tt.ifMatch, w.Code, tt.code)
+		}
+		if tt.code == http.StatusOK && w.Header().Get("ETag") == current {
+			t.Errorf("If-Match %q: ETag not updated", tt.ifMatch)
+		}
+	}
+}
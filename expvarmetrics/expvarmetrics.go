@@ -0,0 +1,69 @@
+package expvarmetrics
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/iortc/iorest"
+)
+
+var (
+	_ iorest.Metrics = (*Metrics)(nil)
+	_ iorest.Counter = (*Metrics)(nil)
+)
+
+var publishMu sync.Mutex
+
+type Metrics struct {
+	timings *expvar.Map
+	samples *expvar.Map
+	counts  *expvar.Map
+}
+
+func New(name string) *Metrics {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+	switch v := expvar.Get(name).(type) {
+	case nil:
+		return newMetrics(expvar.NewMap(name))
+	case *expvar.Map:
+		return newMetrics(v)
+	default:
+		panic(fmt.Sprintf("expvarmetrics: %q is already published as %T", name, v))
+	}
+}
+
+func NewFromMap(root *expvar.Map) *Metrics {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+	return newMetrics(root)
+}
+
+func newMetrics(root *expvar.Map) *Metrics {
+	return &Metrics{
+		timings: child(root, "timings_ms"),
+		samples: child(root, "timings_count"),
+		counts:  child(root, "counts"),
+	}
+}
+
+func child(root *expvar.Map, key string) *expvar.Map {
+	if m, ok := root.Get(key).(*expvar.Map); ok {
+		return m
+	}
+	m := new(expvar.Map).Init()
+	root.Set(key, m)
+	return m
+}
+
+func (m *Metrics) Timing(name, route string, d time.Duration) {
+	key := name + "." + route
+	m.timings.AddFloat(key, float64(d)/float64(time.Millisecond))
+	m.samples.Add(key, 1)
+}
+
+func (m *Metrics) Count(name, route string, n int64) {
+	m.counts.Add(name+"."+route, n)
+}
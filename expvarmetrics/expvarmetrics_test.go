@@ -0,0 +1,56 @@
+package expvarmetrics
+
+import (
+	"expvar"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/iortc/iorest"
+)
+
+func TestMetricsAdapter(t *testing.T) {
+	m := NewFromMap(new(expvar.Map).Init())
+	var metrics iorest.Metrics = m
+	if _, ok := metrics.(iorest.Counter); !ok {
+		t.Fatal("Metrics does not implement iorest.Counter")
+	}
+	s := &iorest.Server{Mux: http.NewServeMux(), Prefix: "/api/", Logger: log.New(io.Discard, "", 0), Metrics: m}
+	s.HandleFunc("users", func(c *iorest.Context) (interface{}, error) {
+		c.Timer("db")()
+		return "ok", nil
+	})
+	if res, _ := s.ServeTest("GET", "/api/users", nil); res.Code != http.StatusOK {
+		t.Fatalf("got %d", res.Code)
+	}
+	m.Timing("manual", "users", 1500*time.Microsecond)
+	m.Timing("manual", "users", 500*time.Microsecond)
+	if v, ok := m.timings.Get("manual.users").(*expvar.Float); !ok || v.Value() != 2 {
+		t.Errorf("timings_ms manual.users = %v", m.timings.Get("manual.users"))
+	}
+	if v, ok := m.samples.Get("manual.users").(*expvar.Int); !ok || v.Value() != 2 {
+		t.Errorf("timings_count manual.users = %v", m.samples.Get("manual.users"))
+	}
+	if m.timings.Get("db.users") == nil || m.samples.Get("db.users") == nil {
+		t.Error("c.Timer not recorded through the adapter")
+	}
+	if v, ok := m.counts.Get("response_bytes.users").(*expvar.Int); !ok || v.Value() != int64(len(`"ok"`+"\n")) {
+		t.Errorf("response_bytes.users = %v", m.counts.Get("response_bytes.users"))
+	}
+}
+
+func TestNewReusesPublishedMap(t *testing.T) {
+	a := New("iorest_test")
+	b := New("iorest_test")
+	a.Count("hits", "users", 1)
+	b.Count("hits", "users", 1)
+	counts := expvar.Get("iorest_test").(*expvar.Map).Get("counts").(*expvar.Map)
+	if a.counts != b.counts || counts != a.counts {
+		t.Fatal("metrics with the same name do not share the published map")
+	}
+	if v := counts.Get("hits.users").(*expvar.Int); v.Value()%2 != 0 || v.Value() == 0 {
+		t.Errorf("hits.users = %d", v.Value())
+	}
+}
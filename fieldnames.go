@@ -0,0 +1,142 @@
+package iorest
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+var (
+	typeOfMarshaler     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	typeOfTextMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+type mappedObject struct {
+	keys   []string
+	values []interface{}
+}
+
+func (o mappedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := enc.Encode(key); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		if err := enc.Encode(o.values[i]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func mapFieldNames(v reflect.Value, mapper func(string) string) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(typeOfMarshaler) || v.Type().Implements(typeOfTextMarshaler) {
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return mapFieldNames(v.Elem(), mapper)
+	case reflect.Struct:
+		obj := mappedObject{}
+		mapStructFields(v, mapper, &obj)
+		return obj
+	case reflect.Map:
+		if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = mapFieldNames(iter.Value(), mapper)
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && (v.IsNil() || v.Type().Elem().Kind() == reflect.Uint8) {
+			return v.Interface()
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = mapFieldNames(v.Index(i), mapper)
+		}
+		return items
+	}
+	return v.Interface()
+}
+
+func mapStructFields(v reflect.Value, mapper func(string) string, obj *mappedObject) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+		if field.Anonymous && name == "" {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				mapStructFields(fv, mapper, obj)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if strings.Contains(","+opts+",", ",omitempty,") && isEmptyValue(fv) {
+			continue
+		}
+		if name == "" {
+			name = mapper(field.Name)
+		}
+		obj.keys = append(obj.keys, name)
+		obj.values = append(obj.values, mapFieldNames(fv, mapper))
+	}
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Struct:
+		return false
+	}
+	return v.IsZero()
+}
+
+func SnakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package iorest
+
+import (
+	"testing"
+	"time"
+)
+
+type accountView struct {
+	UserID    int
+	FirstName string
+	HTTPSURL  string
+	Nickname  string `json:"nick"`
+	Secret    string `json:"-"`
+	Omitted   string `json:",omitempty"`
+	private   string
+	Created   time.Time
+	Address   struct{ StreetName string }
+	Tags      []struct{ TagName string }
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"UserID":    "user_id",
+		"FirstName": "first_name",
+		"HTTPSURL":  "httpsurl",
+		"ID":        "id",
+		"APIKey":    "api_key",
+		"name":      "name",
+	}
+	for in, want := range tests {
+		if got := SnakeCase(in); got != want {
+			t.Errorf("SnakeCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFieldNameMapper(t *testing.T) {
+	s := newTestServer()
+	s.FieldNameMapper = SnakeCase
+	s.HandleFunc("account", func(c *Context) (interface{}, error) {
+		v := accountView{UserID: 7, FirstName: "Ann", Nickname: "annie", Secret: "x", private: "y"}
+		v.Created = time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+		v.Address.StreetName = "Main"
+		v.Tags = append(v.Tags, struct{ TagName string }{"admin"})
+		return &v, nil
+	})
+	res, _ := s.ServeTest("GET", "/api/account", nil)
+	want := `{"user_id":7,"first_name":"Ann","httpsurl":"","nick":"annie","created":"2026-01-02T00:00:00Z","address":{"street_name":"Main"},"tags":[{"tag_name":"admin"}]}` + "\n"
+	if string(res.Body) != want {
+		t.Errorf("got  %s\nwant %s", res.Body, want)
+	}
+}
@@ -0,0 +1,204 @@
+package iorest
+
+import (
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func (c *Context) FormInt(name string, preset int) int {
+	if v, err := c.formInt(name); err == nil {
+		return v
+	}
+	return preset
+}
+
+func (c *Context) FormBool(name string, preset bool) bool {
+	if v, err := c.formBool(name); err == nil {
+		return v
+	}
+	return preset
+}
+
+func (c *Context) FormFloat(name string, preset float64) float64 {
+	if v, err := c.formFloat(name); err == nil {
+		return v
+	}
+	return preset
+}
+
+func (c *Context) ParseFormInt(name string) (int, error) {
+	v, err := c.formInt(name)
+	return v, requestError(err)
+}
+
+func (c *Context) ParseFormBool(name string) (bool, error) {
+	v, err := c.formBool(name)
+	return v, requestError(err)
+}
+
+func (c *Context) ParseFormFloat(name string) (float64, error) {
+	v, err := c.formFloat(name)
+	return v, requestError(err)
+}
+
+func (c *Context) formInt(name string) (int, error) {
+	str, err := c.requiredFormValue(name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, Errorf(http.StatusBadRequest, "Parameter '%s' is not an integer", name)
+	}
+	return v, nil
+}
+
+func (c *Context) formBool(name string) (bool, error) {
+	str, err := c.requiredFormValue(name)
+	if err != nil {
+		return false, err
+	}
+	v, err := strconv.ParseBool(str)
+	if err != nil {
+		return false, Errorf(http.StatusBadRequest, "Parameter '%s' is not a boolean", name)
+	}
+	return v, nil
+}
+
+func (c *Context) formFloat(name string) (float64, error) {
+	str, err := c.requiredFormValue(name)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, Errorf(http.StatusBadRequest, "Parameter '%s' is not a number", name)
+	}
+	return v, nil
+}
+
+func (c *Context) requiredFormValue(name string) (string, error) {
+	str := c.form().Get(name)
+	if str == "" {
+		return "", Errorf(http.StatusBadRequest, "Missing parameter '%s'", name)
+	}
+	return str, nil
+}
+
+func (c *Context) DisallowExtraQuery(allowed ...string) error {
+	known := make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		known[name] = true
+	}
+	var extra []string
+	for name := range c.request.URL.Query() {
+		if !known[name] {
+			extra = append(extra, name)
+		}
+	}
+	if len(extra) == 0 {
+		return nil
+	}
+	sort.Strings(extra)
+	return requestError(Errorf(http.StatusBadRequest, "Unexpected query parameters: %s", strings.Join(extra, ", ")))
+}
+
+func (c *Context) MultipartReader() (*multipart.Reader, error) {
+	mr, err := c.request.MultipartReader()
+	if err != nil {
+		return nil, requestError(Errorf(http.StatusBadRequest, "%s", err.Error()))
+	}
+	return mr, nil
+}
+
+type MultipartLimits struct {
+	MaxTotalBytes int64
+	MaxFiles      int
+	MaxFileBytes  int64
+}
+
+func (c *Context) ParseMultipart(maxMemory int64) (*multipart.Form, error) {
+	limits := c.server.MultipartLimits
+	if limits.MaxTotalBytes > 0 {
+		c.request.Body = http.MaxBytesReader(c.writer, c.request.Body, limits.MaxTotalBytes)
+	}
+	mr, err := c.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	var form *multipart.Form
+	if limits.MaxFiles > 0 || limits.MaxFileBytes > 0 {
+		form, err = readLimitedForm(mr, limits, maxMemory)
+	} else {
+		form, err = mr.ReadForm(maxMemory)
+	}
+	if err != nil {
+		var e Error
+		var sizeErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &e):
+			return nil, requestError(e)
+		case errors.As(err, &sizeErr):
+			return nil, requestError(Errorf(http.StatusRequestEntityTooLarge, "Multipart body exceeds %d bytes", sizeErr.Limit))
+		}
+		return nil, requestError(Errorf(http.StatusBadRequest, "Malformed multipart body: %s", err.Error()))
+	}
+	c.request.MultipartForm = form
+	return form, nil
+}
+
+func readLimitedForm(mr *multipart.Reader, limits MultipartLimits, maxMemory int64) (*multipart.Form, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(copyLimitedParts(mw, mr, limits))
+	}()
+	form, err := multipart.NewReader(pr, mw.Boundary()).ReadForm(maxMemory)
+	pr.Close()
+	<-done
+	return form, err
+}
+
+func copyLimitedParts(mw *multipart.Writer, mr *multipart.Reader, limits MultipartLimits) error {
+	files := 0
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return mw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		w, err := mw.CreatePart(part.Header)
+		if err != nil {
+			return err
+		}
+		if part.FileName() == "" {
+			if _, err := io.Copy(w, part); err != nil {
+				return err
+			}
+			continue
+		}
+		if files++; limits.MaxFiles > 0 && files > limits.MaxFiles {
+			return Errorf(http.StatusRequestEntityTooLarge, "Too many files, at most %d allowed", limits.MaxFiles)
+		}
+		src := io.Reader(part)
+		if limits.MaxFileBytes > 0 {
+			src = io.LimitReader(part, limits.MaxFileBytes+1)
+		}
+		n, err := io.Copy(w, src)
+		if err != nil {
+			return err
+		}
+		if limits.MaxFileBytes > 0 && n > limits.MaxFileBytes {
+			return Errorf(http.StatusRequestEntityTooLarge, "File '%s' exceeds %d bytes", part.FileName(), limits.MaxFileBytes)
+		}
+	}
+}
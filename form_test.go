@@ -0,0 +1,249 @@
+package iorest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTypedFormGetters(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("q", func(c *Context) (interface{}, error) {
+		res := map[string]interface{}{
+			"int":   c.FormInt("n", -1),
+			"bool":  c.FormBool("b", true),
+			"float": c.FormFloat("f", 0.5),
+		}
+		if _, err := c.ParseFormInt("n"); err != nil {
+			res["strict"] = err.Error()
+		}
+		return res, nil
+	})
+	tests := []struct {
+		query string
+		want  map[string]interface{}
+	}{
+		{"n=42&b=false&f=2.5", map[string]interface{}{"int": 42.0, "bool": false, "float": 2.5}},
+		{"n=x&b=maybe&f=NaNx", map[string]interface{}{"int": -1.0, "bool": true, "float": 0.5, "strict": "Parameter 'n' is not an integer"}},
+		{"", map[string]interface{}{"int": -1.0, "bool": true, "float": 0.5, "strict": "Missing parameter 'n'"}},
+	}
+	for _, tt := range tests {
+		res, err := s.ServeTest("GET", "/api/q?"+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := res.Value.(map[string]interface{})
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("%q: %s = %v, want %v", tt.query, k, got[k], v)
+			}
+		}
+	}
+	s.HandleFunc("strict", func(c *Context) (interface{}, error) {
+		return c.ParseFormBool("b")
+	})
+	res, _ := s.ServeTest("GET", "/api/strict?b=nope", nil)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Strict getter failure gave %d, want 400", res.Code)
+	}
+}
+
+func TestMultipartReaderStreams(t *testing.T) {
+	const size = 8 << 20
+	s := newTestServer()
+	s.HandleFunc("upload", func(c *Context) (interface{}, error) {
+		mr, err := c.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+		sizes := map[string]int64{}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			n, err := io.Copy(io.Discard, part)
+			if err != nil {
+				return nil, err
+			}
+			sizes[part.FormName()] = n
+		}
+		return sizes, nil
+	})
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		fw, _ := mw.CreateFormFile("file", "big.bin")
+		chunk := make([]byte, 64<<10)
+		for written := 0; written < size; written += len(chunk) {
+			fw.Write(chunk)
+		}
+		pw.CloseWithError(mw.Close())
+	}()
+	req := httptest.NewRequest("POST", "/api/upload", pr)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != fmt.Sprintf(`{"file":%d}`+"\n", size) {
+		t.Errorf("got %d %q", w.Code, w.Body.String())
+	}
+	res, _ := s.ServeTest("POST", "/api/upload", strings.NewReader("x"))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("non-multipart: got %d, want 400", res.Code)
+	}
+}
+
+func TestFormBodyMethods(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("profile", func(c *Context) (interface{}, error) {
+		return map[string]interface{}{
+			"name": c.FormValue("name", ""),
+			"age":  c.FormInt("age", -1),
+			"lang": c.FormValue("lang", ""),
+		}, nil
+	})
+	for _, method := range []string{"POST", "PUT", "PATCH"} {
+		req := httptest.NewRequest(method, "/api/profile?lang=en", strings.NewReader("name=ann&age=31"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, req)
+		if want := `{"age":31,"lang":"en","name":"ann"}` + "\n"; w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: got %d %q, want %q", method, w.Code, w.Body.String(), want)
+		}
+	}
+}
+
+func TestHasFormValue(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("patch", func(c *Context) (interface{}, error) {
+		return map[string]bool{
+			"form":  c.HasFormValue("name"),
+			"query": c.HasQuery("name"),
+		}, nil
+	})
+	tests := []struct {
+		query, body string
+		want        string
+	}{
+		{"?name=", "", `{"form":true,"query":true}`},
+		{"?name=ann", "", `{"form":true,"query":true}`},
+		{"?other=1", "", `{"form":false,"query":false}`},
+		{"", "name=", `{"form":true,"query":false}`},
+		{"", "", `{"form":false,"query":false}`},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("PATCH", "/api/patch"+tt.query, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, req)
+		if w.Body.String() != tt.want+"\n" {
+			t.Errorf("%q %q: got %q, want %q", tt.query, tt.body, w.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestDisallowExtraQuery(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("search", func(c *Context) (interface{}, error) {
+		if err := c.DisallowExtraQuery("q", "page"); err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+	tests := []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"", http.StatusOK, `"ok"`},
+		{"?q=go&page=2", http.StatusOK, `"ok"`},
+		{"?q=go&pgae=2&sort=x", http.StatusBadRequest, `{"error":400,"reason":"Unexpected query parameters: pgae, sort"}`},
+	}
+	for _, tt := range tests {
+		res, _ := s.ServeTest("GET", "/api/search"+tt.query, nil)
+		if res.Code != tt.code || string(res.Body) != tt.body+"\n" {
+			t.Errorf("%q: got %d %q", tt.query, res.Code, res.Body)
+		}
+	}
+}
+
+func multipartBody(t *testing.T, files map[string]int) (*bytes.Buffer, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("title", "upload")
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fw, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(bytes.Repeat([]byte("x"), files[name]))
+	}
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func TestMultipartLimits(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	tests := []struct {
+		name   string
+		limits MultipartLimits
+		files  map[string]int
+		code   int
+		reason string
+	}{
+		{"within limits", MultipartLimits{MaxTotalBytes: 1 << 20, MaxFiles: 2, MaxFileBytes: 1000}, map[string]int{"a": 1000, "b": 10}, http.StatusOK, ""},
+		{"total bytes", MultipartLimits{MaxTotalBytes: 4096}, map[string]int{"a": 3000, "b": 3000}, http.StatusRequestEntityTooLarge, "exceeds 4096 bytes"},
+		{"file count", MultipartLimits{MaxFiles: 2}, map[string]int{"a": 10, "b": 10, "c": 10}, http.StatusRequestEntityTooLarge, "at most 2 allowed"},
+		{"file size", MultipartLimits{MaxFileBytes: 1000}, map[string]int{"a": 1001, "b": 4 << 20}, http.StatusRequestEntityTooLarge, "File 'a' exceeds 1000 bytes"},
+	}
+	for _, tt := range tests {
+		s := newTestServer()
+		s.MultipartLimits = tt.limits
+		var consumed int64
+		var body *countingReader
+		s.HandleFunc("upload", func(c *Context) (interface{}, error) {
+			form, err := c.ParseMultipart(1)
+			consumed = body.n
+			if err != nil {
+				return nil, err
+			}
+			return map[string]int{"files": len(form.File["file"]), "fields": len(form.Value["title"])}, nil
+		})
+		buf, ct := multipartBody(t, tt.files)
+		total := int64(buf.Len())
+		body = &countingReader{r: buf}
+		req := httptest.NewRequest("POST", "/api/upload", body)
+		req.Header.Set("Content-Type", ct)
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, req)
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.reason) {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, w.Code, w.Body.String(), tt.code, tt.reason)
+		}
+		if tt.code != http.StatusOK && consumed > 1<<20 {
+			t.Errorf("%s: read %d of %d bytes before rejecting", tt.name, consumed, total)
+		}
+		if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+			t.Errorf("%s: %d temp files left behind", tt.name, len(entries))
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package iorest
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+func FileHandler(fsys fs.FS, fallback string) Handler {
+	return func(c *Context) (interface{}, error) {
+		name := "."
+		if _, rest, ok := strings.Cut(c.suffix, "/"); ok {
+			name = path.Clean(rest)
+		}
+		if !fs.ValidPath(name) {
+			c.SetErrorResponseCode(http.StatusNotFound)
+			return nil, Errorf(http.StatusNotFound, "No such file '%s'", name)
+		}
+		if info, err := fs.Stat(fsys, name); err == nil && info.IsDir() {
+			name = path.Join(name, "index.html")
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) && fallback != "" {
+			name = fallback
+			data, err = fs.ReadFile(fsys, name)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			c.SetErrorResponseCode(http.StatusNotFound)
+			return nil, Errorf(http.StatusNotFound, "No such file '%s'", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.SetResourceType(fileContentType(name, data))
+		if c.server.ServePrecompressed {
+			c.SetHeader("Vary", "Accept-Encoding")
+			if acceptsGzip(c.request) {
+				if gz, err := fs.ReadFile(fsys, name+".gz"); err == nil {
+					c.SetHeader("Content-Encoding", "gzip")
+					data = gz
+				}
+			}
+		}
+		c.SetHeader("Content-Length", strconv.Itoa(len(data)))
+		return bytes.NewReader(data), nil
+	}
+}
+
+func fileContentType(name string, data []byte) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return http.DetectContentType(data)
+}
+
+func (s *Server) HandleFS(resource string, fsys fs.FS) {
+	s.HandleFunc(resource, FileHandler(fsys, ""))
+}
+
+func (s *Server) HandleSPA(fsys fs.FS, index string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spaFS, s.spaIndex = fsys, index
+	s.mount()
+}
+
+func (s *Server) serveSPA(w http.ResponseWriter, r *http.Request) bool {
+	s.mu.RLock()
+	fsys, index := s.spaFS, s.spaIndex
+	s.mu.RUnlock()
+	if fsys == nil || (r.Method != "GET" && r.Method != "HEAD") {
+		return false
+	}
+	if q, spec := quality(parseAccept(r.Header.Get("Accept")), "text/html"); q == 0 || spec < 2 {
+		return false
+	}
+	data, err := fs.ReadFile(fsys, index)
+	if err != nil {
+		s.logger().Printf("Failed to read SPA index '%s': %s", index, err.Error())
+		return false
+	}
+	w.Header().Set("Content-Type", fileContentType(index, data))
+	w.Write(data)
+	return true
+}
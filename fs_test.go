@@ -0,0 +1,156 @@
+package iorest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+//go:embed testdata/site
+var embeddedSite embed.FS
+
+func siteFS(t *testing.T) fs.FS {
+	sub, err := fs.Sub(embeddedSite, "testdata/site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func TestHandleFS(t *testing.T) {
+	s := newTestServer()
+	s.HandleFS("static", siteFS(t))
+	s.HandleFunc("app", FileHandler(siteFS(t), "index.html"))
+	tests := []struct {
+		path string
+		code int
+		typ  string
+		body string
+	}{
+		{"/api/static/assets/app.css", http.StatusOK, "text/css; charset=utf-8", "body{color:red}\n"},
+		{"/api/static/data.json", http.StatusOK, "application/json", "{\"x\":1}\n"},
+		{"/api/static/", http.StatusOK, "text/html; charset=utf-8", "<!doctype html><title>app</title>\n"},
+		{"/api/static/missing.js", http.StatusNotFound, "", ""},
+		{"/api/static/../../server.go", http.StatusNotFound, "", ""},
+		{"/api/app/users/42", http.StatusOK, "text/html; charset=utf-8", "<!doctype html><title>app</title>\n"},
+		{"/api/app/assets/app.css", http.StatusOK, "text/css; charset=utf-8", "body{color:red}\n"},
+	}
+	for _, tt := range tests {
+		res, _ := s.ServeTest("GET", tt.path, nil)
+		if res.Code != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.path, res.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		if got := res.Header.Get("Content-Type"); got != tt.typ {
+			t.Errorf("%s: Content-Type %q, want %q", tt.path, got, tt.typ)
+		}
+		if string(res.Body) != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.path, res.Body, tt.body)
+		}
+	}
+}
+
+func TestSPAFallback(t *testing.T) {
+	s := newTestServer()
+	s.HandleSPA(siteFS(t), "index.html")
+	s.HandleFunc("users", func(c *Context) (interface{}, error) { return "users", nil })
+	tests := []struct {
+		method string
+		path   string
+		accept string
+		code   int
+		typ    string
+	}{
+		{"GET", "/api/settings/profile", "text/html,application/xhtml+xml,*/*;q=0.8", http.StatusOK, "text/html; charset=utf-8"},
+		{"GET", "/api/settings/profile", "application/json", http.StatusNotFound, ""},
+		{"GET", "/api/settings/profile", "*/*", http.StatusNotFound, ""},
+		{"POST", "/api/settings/profile", "text/html", http.StatusNotFound, ""},
+		{"GET", "/api/users", "text/html", http.StatusOK, "application/json"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s %s (%s): got %d, want %d", tt.method, tt.path, tt.accept, w.Code, tt.code)
+		}
+		if tt.typ != "" && w.Header().Get("Content-Type") != tt.typ {
+			t.Errorf("%s %s (%s): Content-Type %q", tt.method, tt.path, tt.accept, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	io.WriteString(zw, s)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestServePrecompressed(t *testing.T) {
+	const js, data = "console.log(1)\n", `{"x":1}` + "\n"
+	fsys := fstest.MapFS{
+		"app.js":       {Data: []byte(js)},
+		"app.js.gz":    {Data: gzipped(t, js)},
+		"data.json":    {Data: []byte(data)},
+		"data.json.gz": {Data: gzipped(t, data)},
+		"plain.txt":    {Data: []byte("plain\n")},
+	}
+	tests := []struct {
+		file, typ, body string
+		hasGz           bool
+	}{
+		{"app.js", "text/javascript; charset=utf-8", js, true},
+		{"data.json", "application/json", data, true},
+		{"plain.txt", "text/plain; charset=utf-8", "plain\n", false},
+	}
+	for _, threshold := range []int{0, 1} {
+		s := newTestServer()
+		s.ServePrecompressed = true
+		s.CompressionThreshold = threshold
+		s.HandleFS("static", fsys)
+		for _, gz := range []bool{false, true} {
+			for _, tt := range tests {
+				req := httptest.NewRequest("GET", "/api/static/"+tt.file, nil)
+				if gz {
+					req.Header.Set("Accept-Encoding", "gzip")
+				}
+				w := httptest.NewRecorder()
+				s.serveHTTP(w, req)
+				body := w.Body.Bytes()
+				encoded := w.Header().Get("Content-Encoding") == "gzip"
+				if encoded {
+					zr, err := gzip.NewReader(bytes.NewReader(body))
+					if err != nil {
+						t.Fatalf("%s: %s", tt.file, err)
+					}
+					body, _ = io.ReadAll(zr)
+				}
+				wantEncoded := gz && (tt.hasGz || threshold > 0)
+				if w.Code != http.StatusOK || string(body) != tt.body || encoded != wantEncoded {
+					t.Errorf("threshold=%d gzip=%v %s: got %d %q encoded=%v", threshold, gz, tt.file, w.Code, body, encoded)
+				}
+				if got := w.Header().Get("Content-Type"); got != tt.typ {
+					t.Errorf("threshold=%d gzip=%v %s: Content-Type %q, want %q", threshold, gz, tt.file, got, tt.typ)
+				}
+				if !strings.Contains(strings.Join(w.Header().Values("Vary"), ","), "Accept-Encoding") {
+					t.Errorf("threshold=%d gzip=%v %s: Vary missing", threshold, gz, tt.file)
+				}
+			}
+		}
+	}
+}
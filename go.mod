@@ -1,3 +1,3 @@
 module github.com/iortc/iorest
 
-go 1.14
+go 1.20
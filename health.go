@@ -0,0 +1,21 @@
+package iorest
+
+import (
+	"net/http"
+)
+
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+func (s *Server) HandleReadiness(resource string) {
+	s.HandleFunc(resource, s.readiness)
+}
+
+func (s *Server) readiness(c *Context) (interface{}, error) {
+	if s.Draining() {
+		c.SetErrorResponseCode(http.StatusServiceUnavailable)
+		return nil, Errorf(http.StatusServiceUnavailable, "Server is draining")
+	}
+	return map[string]string{"status": "ready"}, nil
+}
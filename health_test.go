@@ -0,0 +1,23 @@
+package iorest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestReadinessDraining(t *testing.T) {
+	s := newTestServer()
+	s.HandleReadiness("ready")
+	res, _ := s.ServeTest("GET", "/api/ready", nil)
+	if res.Code != http.StatusOK || s.Draining() {
+		t.Fatalf("before Shutdown: got %d, draining %v", res.Code, s.Draining())
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	res, _ = s.ServeTest("GET", "/api/ready", nil)
+	if res.Code != http.StatusServiceUnavailable || !s.Draining() {
+		t.Errorf("after Shutdown: got %d, draining %v", res.Code, s.Draining())
+	}
+}
@@ -0,0 +1,63 @@
+package iorest
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP address '%s'", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func IPFilter(allow, deny []string) Middleware {
+	allowed, err := parseCIDRs(allow)
+	if err != nil {
+		panic(err)
+	}
+	denied, err := parseCIDRs(deny)
+	if err != nil {
+		panic(err)
+	}
+	return func(next Handler) Handler {
+		return func(c *Context) (interface{}, error) {
+			addr, _ := c.ClientAddress()
+			ip := net.ParseIP(addr)
+			if ip == nil || containsIP(denied, ip) || (len(allowed) > 0 && !containsIP(allowed, ip)) {
+				c.SetErrorResponseCode(http.StatusForbidden)
+				return nil, Errorf(http.StatusForbidden, "Access denied for '%s'", addr)
+			}
+			return next(c)
+		}
+	}
+}
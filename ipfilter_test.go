@@ -0,0 +1,43 @@
+package iorest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	s := newTestServer()
+	s.TrustedProxies = []string{"10.0.0.1"}
+	ok := func(c *Context) (interface{}, error) { return "ok", nil }
+	s.HandleFuncWith("admin", ok, IPFilter([]string{"192.168.0.0/16", "2001:db8::/32"}, []string{"192.168.1.13"}))
+	s.HandleFuncWith("open", ok, IPFilter(nil, []string{"203.0.113.0/24"}))
+	tests := []struct {
+		resource  string
+		remote    string
+		forwarded string
+		code      int
+	}{
+		{"admin", "192.168.4.2:1000", "", http.StatusOK},
+		{"admin", "[2001:db8::1]:1000", "", http.StatusOK},
+		{"admin", "192.168.1.13:1000", "", http.StatusForbidden},
+		{"admin", "8.8.8.8:1000", "", http.StatusForbidden},
+		{"admin", "10.0.0.1:1000", "192.168.4.2", http.StatusOK},
+		{"admin", "10.0.0.1:1000", "192.168.1.13", http.StatusForbidden},
+		{"admin", "8.8.8.8:1000", "192.168.4.2", http.StatusForbidden},
+		{"open", "8.8.8.8:1000", "", http.StatusOK},
+		{"open", "203.0.113.9:1000", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/"+tt.resource, nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s from %s (%q): got %d, want %d", tt.resource, tt.remote, tt.forwarded, w.Code, tt.code)
+		}
+	}
+}
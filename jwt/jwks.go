@@ -0,0 +1,148 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/iortc/iorest"
+)
+
+const (
+	minRefetchInterval = time.Minute
+	fetchTimeout       = 10 * time.Second
+)
+
+type jwks struct {
+	url     string
+	client  *http.Client
+	refresh time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetched   time.Time
+	attempted time.Time
+	pending   chan struct{}
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func newJWKS(url string, client *http.Client, refresh time.Duration) *jwks {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if refresh <= 0 {
+		refresh = time.Hour
+	}
+	return &jwks{url: url, client: client, refresh: refresh}
+}
+
+func (j *jwks) key(c *iorest.Context, kid string) (*rsa.PublicKey, error) {
+	j.mu.Lock()
+	key, ok := j.keys[kid]
+	stale := !ok || time.Since(j.fetched) > j.refresh
+	pending := j.pending
+	if !stale || pending != nil || time.Since(j.attempted) <= minRefetchInterval {
+		j.mu.Unlock()
+		if !ok && pending != nil {
+			select {
+			case <-pending:
+			case <-c.Context().Done():
+				return nil, c.Context().Err()
+			}
+			j.mu.Lock()
+			key = j.keys[kid]
+			j.mu.Unlock()
+		}
+		if key == nil {
+			return nil, errors.New("Unknown signing key")
+		}
+		return key, nil
+	}
+	j.attempted = time.Now()
+	j.pending = make(chan struct{})
+	j.mu.Unlock()
+	keys, err := j.fetch(c)
+	j.mu.Lock()
+	if err == nil {
+		j.keys, j.fetched = keys, time.Now()
+	}
+	close(j.pending)
+	j.pending = nil
+	j.mu.Unlock()
+	if err != nil {
+		if ok {
+			c.Warningf("Failed to refresh JWKS, using cached keys: %s", err.Error())
+			return key, nil
+		}
+		return nil, err
+	}
+	if key = keys[kid]; key == nil {
+		return nil, errors.New("Unknown signing key")
+	}
+	return key, nil
+}
+
+func (j *jwks) fetch(c *iorest.Context) (map[string]*rsa.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned %d", res.StatusCode)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pub, err := k.rsaKey()
+		if err != nil {
+			c.Warningf("Skipping JWKS key '%s': %s", k.Kid, err.Error())
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() > 1<<31-1 || exp.Int64() < 3 {
+		return nil, errors.New("Invalid exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
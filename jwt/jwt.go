@@ -0,0 +1,165 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iortc/iorest"
+)
+
+type Claims map[string]interface{}
+
+type Options struct {
+	Secret      []byte
+	Keys        map[string]*rsa.PublicKey
+	JWKSURL     string
+	JWKSRefresh time.Duration
+	HTTPClient  *http.Client
+	Issuer      string
+	Audience    string
+	Leeway      time.Duration
+	ContextKey  string
+
+	AllowMissingExp bool
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func Middleware(opts Options) iorest.Middleware {
+	if opts.ContextKey == "" {
+		opts.ContextKey = "jwt_claims"
+	}
+	var keys *jwks
+	if opts.JWKSURL != "" {
+		keys = newJWKS(opts.JWKSURL, opts.HTTPClient, opts.JWKSRefresh)
+	}
+	return func(next iorest.Handler) iorest.Handler {
+		return func(c *iorest.Context) (interface{}, error) {
+			claims, err := verify(c, opts, keys)
+			if err != nil {
+				c.Debugf("JWT rejected: %s", err.Error())
+				c.SetHeader("WWW-Authenticate", `Bearer error="invalid_token"`)
+				c.SetErrorResponseCode(http.StatusUnauthorized)
+				return nil, iorest.Errorf(http.StatusUnauthorized, "Invalid or missing bearer token")
+			}
+			c.Set(opts.ContextKey, claims)
+			return next(c)
+		}
+	}
+}
+
+func verify(c *iorest.Context, opts Options, keys *jwks) (Claims, error) {
+	auth := c.Header("Authorization")
+	if len(auth) < 7 || !iorest.StrCaseEqual(auth[:7], "Bearer ") {
+		return nil, errors.New("Missing bearer token")
+	}
+	parts := strings.Split(strings.TrimSpace(auth[7:]), ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed token")
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("Malformed signature")
+	}
+	signed := parts[0] + "." + parts[1]
+	switch h.Alg {
+	case "HS256":
+		if len(opts.Secret) == 0 {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, opts.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, errors.New("Invalid signature")
+		}
+	case "RS256":
+		pub := opts.Keys[h.Kid]
+		if pub == nil && keys != nil {
+			if pub, err = keys.key(c, h.Kid); err != nil {
+				return nil, err
+			}
+		}
+		if pub == nil {
+			return nil, errors.New("Unknown signing key")
+		}
+		sum := sha256.Sum256([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+			return nil, errors.New("Invalid signature")
+		}
+	default:
+		return nil, errors.New("Unsupported algorithm '" + h.Alg + "'")
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, claims.validate(opts, time.Now())
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("Malformed token segment")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("Malformed token segment")
+	}
+	return nil
+}
+
+func (claims Claims) validate(opts Options, now time.Time) error {
+	exp, ok := claims.time("exp")
+	if !ok && !opts.AllowMissingExp {
+		return errors.New("Token has no expiry")
+	}
+	if ok && now.After(exp.Add(opts.Leeway)) {
+		return errors.New("Token expired")
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(opts.Leeway).Before(nbf) {
+		return errors.New("Token not yet valid")
+	}
+	if opts.Issuer != "" && claims["iss"] != opts.Issuer {
+		return errors.New("Unexpected issuer")
+	}
+	if opts.Audience != "" && !claims.hasAudience(opts.Audience) {
+		return errors.New("Unexpected audience")
+	}
+	return nil
+}
+
+func (claims Claims) time(name string) (time.Time, bool) {
+	v, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+func (claims Claims) hasAudience(aud string) bool {
+	switch v := claims["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iortc/iorest"
+)
+
+var secret = []byte("test-secret")
+
+func segment(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(t *testing.T, claims Claims) string {
+	signed := segment(t, header{Alg: "HS256"}) + "." + segment(t, claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims Claims) string {
+	signed := segment(t, header{Alg: "RS256", Kid: kid}) + "." + segment(t, claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newServer(opts Options) *iorest.Server {
+	s := &iorest.Server{Mux: http.NewServeMux(), Prefix: "/api/", Logger: log.New(io.Discard, "", 0)}
+	s.HandleFuncWith("me", func(c *iorest.Context) (interface{}, error) {
+		claims, _ := c.Get("jwt_claims").(Claims)
+		return claims["sub"], nil
+	}, Middleware(opts))
+	return s
+}
+
+func serve(s *iorest.Server, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/me", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.Mux.ServeHTTP(w, req)
+	return w
+}
+
+func claims(iss string, exp time.Duration) Claims {
+	return Claims{"sub": "ann", "iss": iss, "aud": []string{"api"}, "exp": float64(time.Now().Add(exp).Unix())}
+}
+
+func TestHS256(t *testing.T) {
+	s := newServer(Options{Secret: secret, Issuer: "auth", Audience: "api"})
+	noExp := claims("auth", time.Hour)
+	delete(noExp, "exp")
+	tampered := signHS256(t, claims("auth", time.Hour))
+	tampered = tampered[:len(tampered)-2] + "AA"
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"valid", signHS256(t, claims("auth", time.Hour)), http.StatusOK},
+		{"expired", signHS256(t, claims("auth", -time.Hour)), http.StatusUnauthorized},
+		{"wrong issuer", signHS256(t, claims("evil", time.Hour)), http.StatusUnauthorized},
+		{"wrong audience", signHS256(t, Claims{"sub": "ann", "iss": "auth", "aud": "other", "exp": float64(time.Now().Add(time.Hour).Unix())}), http.StatusUnauthorized},
+		{"missing exp", signHS256(t, noExp), http.StatusUnauthorized},
+		{"bad signature", tampered, http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+		{"malformed", "not.a-token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := serve(s, tt.token)
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d %q, want %d", tt.name, w.Code, w.Body.String(), tt.code)
+		}
+		if tt.code == http.StatusOK && w.Body.String() != `"ann"`+"\n" {
+			t.Errorf("%s: claims not stashed: %q", tt.name, w.Body.String())
+		}
+		if tt.code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: WWW-Authenticate missing", tt.name)
+		}
+	}
+	s = newServer(Options{Secret: secret, AllowMissingExp: true})
+	if w := serve(s, signHS256(t, noExp)); w.Code != http.StatusOK {
+		t.Errorf("AllowMissingExp: got %d", w.Code)
+	}
+}
+
+func TestRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(Options{Keys: map[string]*rsa.PublicKey{"k1": &key.PublicKey}, Issuer: "auth"})
+	if w := serve(s, signRS256(t, key, "k1", claims("auth", time.Hour))); w.Code != http.StatusOK {
+		t.Errorf("valid: got %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(s, signRS256(t, key, "k1", claims("auth", -time.Hour))); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired: got %d", w.Code)
+	}
+	if w := serve(s, signRS256(t, key, "k2", claims("auth", time.Hour))); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown kid: got %d", w.Code)
+	}
+	if w := serve(s, signHS256(t, claims("auth", time.Hour))); w.Code != http.StatusUnauthorized {
+		t.Errorf("HS256 without a secret: got %d", w.Code)
+	}
+}
+
+func jwkOf(kid string, pub *rsa.PublicKey) jwk {
+	return jwk{
+		Kty: "RSA",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	k1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	k2, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var mu sync.Mutex
+	set := []jwk{jwkOf("k1", &k1.PublicKey)}
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": set})
+	}))
+	defer ts.Close()
+	opts := Options{JWKSURL: ts.URL, Issuer: "auth"}
+	mw := Middleware(opts)
+	s := &iorest.Server{Mux: http.NewServeMux(), Prefix: "/api/", Logger: log.New(io.Discard, "", 0)}
+	s.HandleFuncWith("me", func(c *iorest.Context) (interface{}, error) { return "ok", nil }, mw)
+	token := signRS256(t, k1, "k1", claims("auth", time.Hour))
+	var wg sync.WaitGroup
+	codes := make([]int, 3)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = serve(s, token).Code
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("concurrent request %d: got %d", i, code)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", n)
+	}
+	mu.Lock()
+	set = append(set, jwkOf("k2", &k2.PublicKey))
+	mu.Unlock()
+	rotated := signRS256(t, k2, "k2", claims("auth", time.Hour))
+	if w := serve(s, rotated); w.Code != http.StatusUnauthorized || fetches.Load() != 1 {
+		t.Errorf("refetch within the minimum interval: got %d after %d fetches", w.Code, fetches.Load())
+	}
+	keys := newJWKS(ts.URL, nil, 0)
+	s = &iorest.Server{Mux: http.NewServeMux(), Prefix: "/api/", Logger: log.New(io.Discard, "", 0)}
+	s.HandleFuncWith("me", func(c *iorest.Context) (interface{}, error) {
+		pub, err := keys.key(c, "k2")
+		if err != nil {
+			return nil, err
+		}
+		return pub.N.Cmp(k2.PublicKey.N) == 0, nil
+	})
+	if w := serve(s, ""); w.Body.String() != "true\n" {
+		t.Errorf("rotated key not picked up: %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,80 @@
+package iorest
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type fieldLogger struct {
+	logger Logger
+	fields []interface{}
+}
+
+func (l *fieldLogger) Printf(format string, v ...interface{}) {
+	l.logger.Printf("[%s] %s %s: "+format, append(l.fields[:len(l.fields):len(l.fields)], v...)...)
+}
+
+func (s *Server) logger() Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return log.Default()
+}
+
+type SampledLogger struct {
+	Logger Logger
+	Window time.Duration
+	Burst  int
+
+	mu      sync.Mutex
+	samples map[string]*sample
+}
+
+type sample struct {
+	start time.Time
+	count int
+	last  string
+}
+
+var logSanitizer = strings.NewReplacer("\n", `\n`, "\r", `\r`)
+
+func NewSampledLogger(logger Logger, window time.Duration, burst int) *SampledLogger {
+	return &SampledLogger{Logger: logger, Window: window, Burst: burst}
+}
+
+func (l *SampledLogger) Printf(format string, v ...interface{}) {
+	msg := logSanitizer.Replace(fmt.Sprintf(format, v...))
+	now := time.Now()
+	l.mu.Lock()
+	if l.samples == nil {
+		l.samples = make(map[string]*sample)
+	}
+	var summary string
+	s := l.samples[format]
+	if s == nil || now.Sub(s.start) >= l.Window {
+		if s != nil && s.count > l.Burst {
+			summary = fmt.Sprintf("%d similar messages suppressed in last %s, last: %s", s.count-l.Burst, l.Window, s.last)
+		}
+		s = &sample{start: now}
+		l.samples[format] = s
+	}
+	s.count++
+	pass := s.count <= l.Burst
+	if !pass {
+		s.last = msg
+	}
+	l.mu.Unlock()
+	if summary != "" {
+		l.Logger.Printf("%s", summary)
+	}
+	if pass {
+		l.Logger.Printf("%s", msg)
+	}
+}
@@ -0,0 +1,74 @@
+package iorest
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type captureLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *captureLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *captureLogger) output() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+func TestContextLoggerFields(t *testing.T) {
+	s := newTestServer()
+	logger := &captureLogger{}
+	s.Logger = logger
+	s.HandleFunc("orders", func(c *Context) (interface{}, error) {
+		c.Warningf("Stock low for %s", "widget")
+		c.Logger().Printf("Custom %d", 42)
+		return nil, nil
+	})
+	r := httptest.NewRequest("DELETE", "/api/orders/9", nil)
+	r.Header.Set("X-Request-Id", "req-1")
+	s.serveHTTP(httptest.NewRecorder(), r)
+	lines := logger.output()
+	want := []string{
+		"[req-1] DELETE /api/orders/9: Stock low for widget",
+		"[req-1] DELETE /api/orders/9: Custom 42",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got log lines %q, want %q", lines, want)
+	}
+}
+
+func TestSampledLogger(t *testing.T) {
+	out := &captureLogger{}
+	l := NewSampledLogger(out, 20*time.Millisecond, 2)
+	for i := 0; i < 10; i++ {
+		l.Printf("Bad request from %s", "10.0.0.1")
+	}
+	l.Printf("Other %s", "line\nwith newline")
+	if lines := out.output(); len(lines) != 3 {
+		t.Fatalf("Got %d lines before window end: %q", len(lines), lines)
+	}
+	time.Sleep(25 * time.Millisecond)
+	l.Printf("Bad request from %s", "10.0.0.2")
+	lines := out.output()
+	want := []string{
+		"Bad request from 10.0.0.1",
+		"Bad request from 10.0.0.1",
+		`Other line\nwith newline`,
+		"8 similar messages suppressed in last 20ms, last: Bad request from 10.0.0.1",
+		"Bad request from 10.0.0.2",
+	}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("Got %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,52 @@
+package iorest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type Metrics interface {
+	Timing(name, route string, d time.Duration)
+}
+
+type Counter interface {
+	Count(name, route string, n int64)
+}
+
+func (c *Context) Timer(name string) func() {
+	m, route, start := c.server.Metrics, c.route, time.Now()
+	return func() {
+		if m != nil {
+			m.Timing(name, route, time.Since(start))
+		}
+	}
+}
+
+func (c *Context) count(name string) {
+	if m, ok := c.server.Metrics.(Counter); ok {
+		m.Count(name, c.route, 1)
+	}
+}
+
+func (c *Context) recordResponse() {
+	if m, ok := c.server.Metrics.(Counter); ok {
+		m.Count("response_bytes", c.route, c.rw.size)
+	}
+	c.Debugf("Responded %d with %d bytes in %s", c.rw.status, c.rw.size, c.Elapsed())
+}
+
+func (c *Context) AddServerTiming(name string, d time.Duration, desc string) {
+	entry := name
+	if desc != "" {
+		entry += ";desc=" + strconv.Quote(desc)
+	}
+	entry += ";dur=" + strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+	if c.header == nil {
+		c.header = make(http.Header)
+	}
+	if prev := c.header.Get("Server-Timing"); prev != "" {
+		entry = prev + ", " + entry
+	}
+	c.header.Set("Server-Timing", entry)
+}
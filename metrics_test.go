@@ -0,0 +1,90 @@
+package iorest
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMetrics struct {
+	mu      sync.Mutex
+	timings map[string]time.Duration
+	counts  map[string]int64
+}
+
+func (m *fakeMetrics) Timing(name, route string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.timings == nil {
+		m.timings = make(map[string]time.Duration)
+	}
+	m.timings[name+"."+route] += d
+}
+
+func (m *fakeMetrics) Count(name, route string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts == nil {
+		m.counts = make(map[string]int64)
+	}
+	m.counts[name+"."+route] += n
+}
+
+func (m *fakeMetrics) count(key string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[key]
+}
+
+func TestResponseSizeRecorded(t *testing.T) {
+	s := newTestServer()
+	metrics := &fakeMetrics{}
+	s.Metrics = metrics
+	s.HandleFunc("text", func(c *Context) (interface{}, error) {
+		c.SetResourceType("text/plain")
+		return strings.Repeat("x", 100), nil
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := s.ServeTest("GET", "/api/text", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := metrics.count("response_bytes.text"); n != 200 {
+		t.Errorf("Recorded %d response bytes, want 200", n)
+	}
+}
+
+func TestTimer(t *testing.T) {
+	s := newTestServer()
+	m := &fakeMetrics{}
+	s.Metrics = m
+	s.HandleFunc("users", func(c *Context) (interface{}, error) {
+		stop := c.Timer("db_query")
+		time.Sleep(5 * time.Millisecond)
+		stop()
+		return nil, nil
+	})
+	s.ServeTest("GET", "/api/users", nil)
+	m.mu.Lock()
+	d, ok := m.timings["db_query.users"]
+	m.mu.Unlock()
+	if !ok || d < 5*time.Millisecond {
+		t.Errorf("db_query.users = %s, %v; want >= 5ms", d, ok)
+	}
+}
+
+func TestServerTiming(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("page", func(c *Context) (interface{}, error) {
+		c.AddServerTiming("db", 53200*time.Microsecond, "")
+		c.AddServerTiming("cache", 1500*time.Microsecond, "Cache \"hot\" read")
+		c.AddServerTiming("app", 0, "")
+		return nil, nil
+	})
+	res, _ := s.ServeTest("GET", "/api/page", nil)
+	want := `db;dur=53.2, cache;desc="Cache \"hot\" read";dur=1.5, app;dur=0`
+	if got := res.Header.Values("Server-Timing"); len(got) != 1 || got[0] != want {
+		t.Errorf("Server-Timing = %q, want one header %q", got, want)
+	}
+}
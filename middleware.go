@@ -0,0 +1,85 @@
+package iorest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+type Middleware func(Handler) Handler
+
+func chain(handler Handler, mw []Middleware) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		handler = mw[i](handler)
+	}
+	return handler
+}
+
+func (s *Server) Use(mw ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, mw...)
+	s.resetChains()
+}
+
+func (s *Server) HandleFuncWith(resource string, handler Handler, mw ...Middleware) {
+	s.HandleFunc(resource, chain(recoverPanics(handler), mw))
+}
+
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(c *Context) (interface{}, error) {
+			ctx, cancel := context.WithTimeout(c.ctx, d)
+			c.onRelease(cancel)
+			c.ctx = ctx
+			return next(c)
+		}
+	}
+}
+
+func RequestID() Middleware {
+	return func(next Handler) Handler {
+		return func(c *Context) (interface{}, error) {
+			if c.reqID == "" {
+				gen := c.server.RequestIDGenerator
+				if gen == nil {
+					gen = defaultRequestID
+				}
+				c.reqID = gen()
+				c.logger = nil
+			}
+			c.SetHeader("X-Request-Id", c.reqID)
+			return next(c)
+		}
+	}
+}
+
+func defaultRequestID() string {
+	return randomHex(16)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func RequireTLS() Middleware {
+	return func(next Handler) Handler {
+		return func(c *Context) (interface{}, error) {
+			if c.IsSecure() {
+				return next(c)
+			}
+			if c.Method() == "GET" || c.Method() == "HEAD" {
+				location := "https://" + c.Host() + c.request.URL.RequestURI()
+				return Response{Status: http.StatusMovedPermanently, Header: http.Header{"Location": {location}}}, nil
+			}
+			c.SetErrorResponseCode(http.StatusForbidden)
+			return nil, Errorf(http.StatusForbidden, "TLS required")
+		}
+	}
+}
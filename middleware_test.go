@@ -0,0 +1,252 @@
+package iorest
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tagMiddleware(tag string) Middleware {
+	return func(next Handler) Handler {
+		return func(c *Context) (interface{}, error) {
+			trace, _ := c.Get("trace").(string)
+			c.Set("trace", trace+tag+">")
+			return next(c)
+		}
+	}
+}
+
+func TestHandleFuncWith(t *testing.T) {
+	s := newTestServer()
+	s.Use(tagMiddleware("global"))
+	handler := func(c *Context) (interface{}, error) {
+		trace, _ := c.Get("trace").(string)
+		return trace + "handler", nil
+	}
+	s.HandleFuncWith("admin", handler, tagMiddleware("auth"), tagMiddleware("audit"))
+	s.HandleFunc("public", handler)
+	tests := map[string]string{
+		"admin":  "global>auth>audit>handler",
+		"public": "global>handler",
+	}
+	for resource, want := range tests {
+		res, err := s.ServeTest("GET", "/api/"+resource, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Value; got != want {
+			t.Errorf("%s: chain %q, want %q", resource, got, want)
+		}
+	}
+}
+
+func TestTimeRemaining(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("none", func(c *Context) (interface{}, error) {
+		_, ok := c.TimeRemaining()
+		return ok, nil
+	})
+	s.HandleFuncWith("budget", func(c *Context) (interface{}, error) {
+		first, ok := c.TimeRemaining()
+		if !ok {
+			return nil, Errorf(http.StatusInternalServerError, "No deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+		second, _ := c.TimeRemaining()
+		if second >= first || first > time.Second {
+			return nil, Errorf(http.StatusInternalServerError, "Budget went from %s to %s", first, second)
+		}
+		return true, nil
+	}, Timeout(time.Second))
+	for resource, want := range map[string]interface{}{"none": false, "budget": true} {
+		res, err := s.ServeTest("GET", "/api/"+resource, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Value != want {
+			t.Errorf("%s: got %s", resource, res.Body)
+		}
+	}
+}
+
+func TestTimeoutExceeded(t *testing.T) {
+	s := newTestServer()
+	s.HandleFuncWith("slow", func(c *Context) (interface{}, error) {
+		<-c.Context().Done()
+		return nil, c.Context().Err()
+	}, Timeout(10*time.Millisecond))
+	res, err := s.ServeTest("GET", "/api/slow", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != http.StatusGatewayTimeout {
+		t.Errorf("Got %d, want 504", res.Code)
+	}
+}
+
+func TestTimeoutOutlivesChain(t *testing.T) {
+	s := newTestServer()
+	s.Use(Timeout(time.Minute))
+	var goErr error
+	s.AwaitGoroutines = true
+	s.HandleFunc("events", func(c *Context) (interface{}, error) {
+		c.Go(func(ctx context.Context) {
+			time.Sleep(5 * time.Millisecond)
+			goErr = ctx.Err()
+		})
+		ch := make(chan interface{}, 5)
+		for i := 0; i < 5; i++ {
+			ch <- i
+		}
+		close(ch)
+		return ch, nil
+	})
+	res, err := s.ServeTest("GET", "/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(res.Body); got != "0\n1\n2\n3\n4\n" {
+		t.Errorf("Streamed %q under Timeout", got)
+	}
+	if goErr != nil {
+		t.Errorf("Goroutine context was canceled while the request ran: %v", goErr)
+	}
+}
+
+func TestRequestIDGenerator(t *testing.T) {
+	s := newTestServer()
+	n := 0
+	s.RequestIDGenerator = func() string {
+		n++
+		return fmt.Sprintf("req-%d", n)
+	}
+	s.Use(RequestID())
+	s.HandleFunc("ping", func(c *Context) (interface{}, error) {
+		return nil, nil
+	})
+	for _, want := range []string{"req-1", "req-2"} {
+		res, _ := s.ServeTest("GET", "/api/ping", nil)
+		if got := res.Header.Get("X-Request-Id"); got != want {
+			t.Errorf("X-Request-Id = %q, want %q", got, want)
+		}
+	}
+	req := httptest.NewRequest("GET", "/api/ping", nil)
+	req.Header.Set("X-Request-Id", "upstream")
+	w := httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	if got := w.Header().Get("X-Request-Id"); got != "upstream" || n != 2 {
+		t.Errorf("incoming id: got %q after %d generations", got, n)
+	}
+	s = newTestServer()
+	s.Use(RequestID())
+	s.HandleFunc("ping", func(c *Context) (interface{}, error) {
+		return nil, nil
+	})
+	res, _ := s.ServeTest("GET", "/api/ping", nil)
+	if got := res.Header.Get("X-Request-Id"); len(got) != 32 {
+		t.Errorf("default id = %q, want 32 hex chars", got)
+	}
+}
+
+func TestRequireTLS(t *testing.T) {
+	s := newTestServer()
+	s.TrustedProxies = []string{"10.0.0.0/8"}
+	s.HandleFuncWith("secret", func(c *Context) (interface{}, error) {
+		return "secret", nil
+	}, RequireTLS())
+	tests := []struct {
+		name, method, remote, proto string
+		tls                         bool
+		code                        int
+		location                    string
+	}{
+		{"direct TLS", "POST", "192.0.2.1:1234", "", true, http.StatusOK, ""},
+		{"forwarded", "POST", "10.1.2.3:1234", "https", false, http.StatusOK, ""},
+		{"untrusted forwarded", "POST", "192.0.2.1:1234", "https", false, http.StatusForbidden, ""},
+		{"plain POST", "POST", "192.0.2.1:1234", "", false, http.StatusForbidden, ""},
+		{"plain GET", "GET", "192.0.2.1:1234", "", false, http.StatusMovedPermanently, "https://example.com/api/secret?x=1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://example.com/api/secret?x=1", strings.NewReader(""))
+		req.RemoteAddr = tt.remote
+		if tt.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if tt.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, req)
+		if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, w.Code, w.Header().Get("Location"), tt.code, tt.location)
+		}
+	}
+}
+
+func TestPanicErrorSurfaced(t *testing.T) {
+	s := newTestServer()
+	var seen error
+	s.Use(func(next Handler) Handler {
+		return func(c *Context) (interface{}, error) {
+			res, err := next(c)
+			seen = err
+			return res, err
+		}
+	})
+	s.HandleFunc("boom", func(c *Context) (interface{}, error) {
+		panic("kaboom")
+	})
+	s.HandleFunc("fail", func(c *Context) (interface{}, error) {
+		return nil, errors.New("plain failure")
+	})
+	res, _ := s.ServeTest("GET", "/api/boom", nil)
+	var pe *PanicError
+	if !errors.As(seen, &pe) || pe.Value != "kaboom" || !strings.Contains(string(pe.Stack), "TestPanicErrorSurfaced") {
+		t.Fatalf("middleware saw %#v, want *PanicError with value and stack", seen)
+	}
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("boom: got %d, want 500", res.Code)
+	}
+	s.ServeTest("GET", "/api/fail", nil)
+	if seen == nil || errors.As(seen, &pe) {
+		t.Errorf("plain error classified as panic: %#v", seen)
+	}
+}
+
+func TestRouteMiddlewareSeesPanics(t *testing.T) {
+	s := newTestServer()
+	var global, route error
+	s.Use(func(next Handler) Handler {
+		return func(c *Context) (interface{}, error) {
+			res, err := next(c)
+			global = err
+			return res, err
+		}
+	})
+	s.HandleFuncWith("boom", func(c *Context) (interface{}, error) {
+		panic("kaboom")
+	}, func(next Handler) Handler {
+		return func(c *Context) (interface{}, error) {
+			res, err := next(c)
+			route = err
+			return res, err
+		}
+	})
+	res, _ := s.ServeTest("GET", "/api/boom", nil)
+	var pe *PanicError
+	if !errors.As(route, &pe) || pe.Value != "kaboom" {
+		t.Errorf("route middleware saw %#v, want *PanicError", route)
+	}
+	if !errors.As(global, &pe) || pe.Value != "kaboom" {
+		t.Errorf("global middleware saw %#v, want *PanicError", global)
+	}
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("got %d, want 500", res.Code)
+	}
+}
@@ -0,0 +1,151 @@
+package iorest
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type AcceptType struct {
+	Type    string
+	Subtype string
+	Q       float64
+}
+
+func (m AcceptType) specificity(t, sub string) int {
+	switch {
+	case m.Type == t && m.Subtype == sub:
+		return 3
+	case m.Type == t && m.Subtype == "*":
+		return 2
+	case m.Type == "*" && m.Subtype == "*":
+		return 1
+	}
+	return 0
+}
+
+func parseAccept(header string) []AcceptType {
+	var ranges []AcceptType
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		media := strings.ToLower(strings.TrimSpace(params[0]))
+		slash := strings.IndexByte(media, '/')
+		if slash <= 0 || slash == len(media)-1 {
+			continue
+		}
+		m := AcceptType{Type: media[:slash], Subtype: media[slash+1:], Q: 1}
+		valid := true
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "q") {
+				q, err := strconv.ParseFloat(kv[1], 64)
+				if err != nil || q < 0 || q > 1 {
+					valid = false
+				}
+				m.Q = q
+			}
+		}
+		if valid {
+			ranges = append(ranges, m)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].Q > ranges[j].Q
+	})
+	return ranges
+}
+
+func quality(ranges []AcceptType, contentType string) (float64, int) {
+	slash := strings.IndexByte(contentType, '/')
+	if slash < 0 {
+		return 0, 0
+	}
+	t, sub := contentType[:slash], contentType[slash+1:]
+	q, best := 0.0, 0
+	for _, m := range ranges {
+		if spec := m.specificity(t, sub); spec > best {
+			q, best = m.Q, spec
+		}
+	}
+	return q, best
+}
+
+func (s *Server) defaultType() string {
+	if s.DefaultContentType != "" {
+		return s.DefaultContentType
+	}
+	return "application/json"
+}
+
+func (s *Server) available() []string {
+	candidates := []string{s.defaultType()}
+	if candidates[0] != "application/json" {
+		candidates = append(candidates, "application/json")
+	}
+	n := len(candidates)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for t := range s.codecs {
+		if t != candidates[0] {
+			candidates = append(candidates, t)
+		}
+	}
+	sort.Strings(candidates[n:])
+	return candidates
+}
+
+func (s *Server) negotiate(r *http.Request) (string, bool) {
+	header := r.Header.Get("Accept")
+	if header == "" {
+		return s.defaultType(), true
+	}
+	ranges := parseAccept(header)
+	candidates := s.available()
+	chosen, chosenQ, chosenSpec := candidates[0], 0.0, 0
+	for _, t := range candidates {
+		q, spec := quality(ranges, t)
+		if q > chosenQ || (q == chosenQ && q > 0 && spec > chosenSpec) {
+			chosen, chosenQ, chosenSpec = t, q, spec
+		}
+	}
+	return chosen, chosenQ > 0
+}
+
+func isTextType(t string) bool {
+	return strings.HasPrefix(t, "text/") || isJSONType(t) || strings.HasSuffix(strings.SplitN(t, ";", 2)[0], "+xml")
+}
+
+func acceptsUTF8(header string) bool {
+	if strings.TrimSpace(header) == "" {
+		return true
+	}
+	utf8, wildcard := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		charset := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "q") {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		switch charset {
+		case "utf-8", "utf8":
+			utf8 = q
+		case "*":
+			wildcard = q
+		}
+	}
+	if utf8 >= 0 {
+		return utf8 > 0
+	}
+	return wildcard > 0
+}
+
+func (c *Context) AcceptedTypes() []AcceptType {
+	return parseAccept(c.request.Header.Get("Accept"))
+}
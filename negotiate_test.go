@@ -0,0 +1,149 @@
+package iorest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type textCodec struct{}
+
+func (textCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(fmt.Sprint(v)), nil
+}
+
+func (textCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*string) = string(data)
+	return nil
+}
+
+func TestNegotiateWildcards(t *testing.T) {
+	plain := newTestServer()
+	plain.RegisterCodec("text/plain", textCodec{})
+	jsonOnly := newTestServer()
+	tests := []struct {
+		server     *Server
+		accept     string
+		want       string
+		acceptable bool
+	}{
+		{plain, "application/*;q=0.9, text/plain;q=1.0", "text/plain", true},
+		{jsonOnly, "application/*;q=0.9, text/plain;q=1.0", "application/json", true},
+		{plain, "*/*", "application/json", true},
+		{plain, "text/*", "text/plain", true},
+		{plain, "text/plain;q=0.2, */*;q=0.1", "text/plain", true},
+		{plain, "text/plain;q=0.5, application/json;q=0.5", "application/json", true},
+		{plain, "text/*;q=0.5, application/json;q=0.4", "text/plain", true},
+		{plain, "*/*;q=0.8, application/json;q=0", "text/plain", true},
+		{plain, "image/png", "application/json", false},
+		{plain, "", "application/json", true},
+		{plain, "application/json;q=bogus, text/plain", "text/plain", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/x", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		got, ok := tt.server.negotiate(r)
+		if got != tt.want || ok != tt.acceptable {
+			t.Errorf("Accept %q: got %s %v, want %s %v", tt.accept, got, ok, tt.want, tt.acceptable)
+		}
+	}
+}
+
+func TestAcceptCharset(t *testing.T) {
+	s := newTestServer()
+	calls := 0
+	s.HandleFunc("note", func(c *Context) (interface{}, error) {
+		calls++
+		return "hi", nil
+	})
+	s.HandleFunc("page", func(c *Context) (interface{}, error) {
+		calls++
+		c.SetResourceType("text/html")
+		return "<p>hi</p>", nil
+	})
+	s.HandleFunc("blob", func(c *Context) (interface{}, error) {
+		calls++
+		c.SetResourceType("application/octet-stream")
+		return []byte{1, 2}, nil
+	})
+	tests := []struct {
+		path, charset string
+		code, calls   int
+	}{
+		{"/api/note", "", http.StatusOK, 1},
+		{"/api/note", "utf-8", http.StatusOK, 1},
+		{"/api/note", "iso-8859-1, *;q=0.5", http.StatusOK, 1},
+		{"/api/note", "*", http.StatusOK, 1},
+		{"/api/note", "iso-8859-1", http.StatusNotAcceptable, 0},
+		{"/api/note", "utf-8;q=0, *", http.StatusNotAcceptable, 0},
+		{"/api/page", "iso-8859-1", http.StatusNotAcceptable, 0},
+		{"/api/blob", "iso-8859-1", http.StatusNotAcceptable, 0},
+	}
+	for _, tt := range tests {
+		calls = 0
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.charset != "" {
+			req.Header.Set("Accept-Charset", tt.charset)
+		}
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, req)
+		if w.Code != tt.code || calls != tt.calls {
+			t.Errorf("%s %q: got %d after %d calls, want %d after %d", tt.path, tt.charset, w.Code, calls, tt.code, tt.calls)
+		}
+	}
+}
+
+func TestStrictNegotiation(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("users", func(c *Context) (interface{}, error) {
+		return []string{"ann"}, nil
+	})
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/users", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, req)
+		return w
+	}
+	if w := get("application/xml"); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("lenient: got %d %q, want JSON fallback", w.Code, w.Header().Get("Content-Type"))
+	}
+	s.StrictNegotiation = true
+	w := get("application/xml")
+	if w.Code != http.StatusNotAcceptable || !strings.Contains(w.Body.String(), "application/json") {
+		t.Errorf("strict: got %d %q, want 406 listing available types", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("strict: 406 Content-Type = %q, want text/plain", ct)
+	}
+	if w := get("application/xml, */*;q=0.1"); w.Code != http.StatusOK {
+		t.Errorf("strict with wildcard: got %d, want 200", w.Code)
+	}
+}
+
+func TestAcceptedTypes(t *testing.T) {
+	s := newTestServer()
+	var got []AcceptType
+	s.HandleFunc("neg", func(c *Context) (interface{}, error) {
+		got = c.AcceptedTypes()
+		return nil, nil
+	})
+	req := httptest.NewRequest("GET", "/api/neg", nil)
+	req.Header.Set("Accept", "text/*;q=0.3, text/HTML;level=1, application/json;q=0.9, */*;q=0.1, bogus, image/png;q=2, application/xml;q=0.9")
+	s.serveHTTP(httptest.NewRecorder(), req)
+	want := []AcceptType{
+		{"text", "html", 1},
+		{"application", "json", 0.9},
+		{"application", "xml", 0.9},
+		{"text", "*", 0.3},
+		{"*", "*", 0.1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AcceptedTypes() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,31 @@
+package iorest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+type SchemaValidator interface {
+	Validate(schema, document []byte) ([]string, error)
+}
+
+func (c *Context) ValidateSchema(schema []byte) error {
+	if c.server.SchemaValidator == nil {
+		return requestError(Errorf(http.StatusInternalServerError, "No schema validator configured"))
+	}
+	data, err := ioutil.ReadAll(c.request.Body)
+	if err != nil {
+		return requestError(jsonError(err))
+	}
+	c.request.Body = ioutil.NopCloser(bytes.NewReader(data))
+	violations, err := c.server.SchemaValidator.Validate(schema, data)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return requestError(Errorf(http.StatusBadRequest, "Schema validation failed: %s", strings.Join(violations, "; ")))
+	}
+	return nil
+}
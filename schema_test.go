@@ -0,0 +1,56 @@
+package iorest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type requiredFields struct{}
+
+func (requiredFields) Validate(schema, document []byte) ([]string, error) {
+	var s struct{ Required []string }
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return []string{"document is not an object"}, nil
+	}
+	var violations []string
+	for _, field := range s.Required {
+		if _, ok := doc[field]; !ok {
+			violations = append(violations, "missing "+field)
+		}
+	}
+	return violations, nil
+}
+
+func TestValidateSchema(t *testing.T) {
+	schema := []byte(`{"required":["name","email"]}`)
+	s := newTestServer()
+	s.HandleFunc("users", func(c *Context) (interface{}, error) {
+		if err := c.ValidateSchema(schema); err != nil {
+			return nil, err
+		}
+		var u map[string]string
+		if err := c.ParseJson(&u); err != nil {
+			return nil, err
+		}
+		return u["name"], nil
+	})
+	res, _ := s.ServeTest("POST", "/api/users", strings.NewReader(`{"name":"ann"}`))
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("no validator: got %d, want 500", res.Code)
+	}
+	s.SchemaValidator = requiredFields{}
+	res, _ = s.ServeTest("POST", "/api/users", strings.NewReader(`{"name":"ann","email":"a@b"}`))
+	if res.Code != http.StatusOK || res.Value != "ann" {
+		t.Errorf("conforming: got %d %q", res.Code, res.Body)
+	}
+	res, _ = s.ServeTest("POST", "/api/users", strings.NewReader(`{"name":"ann"}`))
+	if res.Code != http.StatusBadRequest || !strings.Contains(string(res.Body), "missing email") {
+		t.Errorf("non-conforming: got %d %q", res.Code, res.Body)
+	}
+}
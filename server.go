@@ -1,15 +1,28 @@
 package iorest
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
-	"log"
+	"mime"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func StrCaseEqual(a, b string) bool {
@@ -17,36 +30,200 @@ func StrCaseEqual(a, b string) bool {
 }
 
 type Error struct {
-	Code   int    `json:"error"`
-	Reason string `json:"reason"`
+	Code       int           `json:"error"`
+	Reason     string        `json:"reason"`
+	Type       string        `json:"type,omitempty"`
+	Retryable  bool          `json:"retryable,omitempty"`
+	RetryAfter time.Duration `json:"-"`
+	status     int
+	cause      error
 }
 
 func (e Error) Error() string {
 	return e.Reason
 }
 
+func (e Error) Unwrap() error {
+	return e.cause
+}
+
 func Errorf(code int, format string, v ...interface{}) Error {
 	return Error{Code: code, Reason: fmt.Sprintf(format, v...)}
 }
 
+func TypedErrorf(code int, typ, format string, v ...interface{}) Error {
+	return Error{Code: code, Reason: fmt.Sprintf(format, v...), Type: typ}
+}
+
+func BadRequestf(format string, v ...interface{}) Error {
+	return TypedErrorf(http.StatusBadRequest, "bad_request", format, v...)
+}
+
+func ValidationErrorf(format string, v ...interface{}) Error {
+	return TypedErrorf(http.StatusUnprocessableEntity, "validation_error", format, v...)
+}
+
+func Unauthorizedf(format string, v ...interface{}) Error {
+	return TypedErrorf(http.StatusUnauthorized, "unauthorized", format, v...)
+}
+
+func Forbiddenf(format string, v ...interface{}) Error {
+	return TypedErrorf(http.StatusForbidden, "forbidden", format, v...)
+}
+
+func NotFoundf(format string, v ...interface{}) Error {
+	return TypedErrorf(http.StatusNotFound, "not_found", format, v...)
+}
+
+func Conflictf(format string, v ...interface{}) Error {
+	return TypedErrorf(http.StatusConflict, "conflict", format, v...)
+}
+
+func RetryableErrorf(code int, retryAfter time.Duration, format string, v ...interface{}) Error {
+	return Error{Code: code, Reason: fmt.Sprintf(format, v...), Retryable: true, RetryAfter: retryAfter}
+}
+
 type Context struct {
+	server  *Server
+	writer  http.ResponseWriter
 	request *http.Request
-	paths   []string
+	body    io.ReadCloser
+	rw      *responseWriter
+	ctx     context.Context
+	reqID   string
+	logger  Logger
+	route   string
+	suffix  string
 	resType string
 	resCode int
+	status  int
+	header  http.Header
+	trailer http.Header
+	values  map[string]interface{}
+	sniff   bool
+	start   time.Time
+	session *SessionData
+	missing NotFoundKind
+	tasks   *sync.WaitGroup
+	release []func()
+}
+
+func (c *Context) Logger() Logger {
+	if c.logger == nil {
+		reqID := c.reqID
+		if reqID == "" {
+			reqID = "-"
+		}
+		c.logger = &fieldLogger{logger: c.server.logger(), fields: []interface{}{reqID, c.request.Method, c.request.URL.Path}}
+	}
+	return c.logger
+}
+
+func (c *Context) Debugf(format string, v ...interface{}) {
+	if c.server.Debug {
+		c.Logger().Printf(format, v...)
+	}
 }
 
 func (c *Context) Warningf(format string, v ...interface{}) {
-	log.Printf(format, v...)
+	c.Logger().Printf(format, v...)
 }
 
 func (c *Context) Errorf(format string, v ...interface{}) {
-	log.Printf(format, v...)
+	c.Logger().Printf(format, v...)
+}
+
+func (c *Context) RequestID() string {
+	return c.reqID
+}
+
+func (c *Context) Context() context.Context {
+	return c.ctx
+}
+
+func (c *Context) StartTime() time.Time {
+	return c.start
+}
+
+func (c *Context) Elapsed() time.Duration {
+	return time.Since(c.start)
+}
+
+func (c *Context) TimeRemaining() (time.Duration, bool) {
+	deadline, ok := c.ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+func (c *Context) SetWriteDeadline(t time.Time) error {
+	return http.NewResponseController(c.writer).SetWriteDeadline(t)
+}
+
+func (c *Context) SetReadDeadline(t time.Time) error {
+	return http.NewResponseController(c.writer).SetReadDeadline(t)
 }
 
 func (c *Context) ClientAddress() (string, error) {
 	host, _, err := net.SplitHostPort(c.request.RemoteAddr)
-	return host, err
+	if err != nil {
+		return host, err
+	}
+	if !c.fromTrustedProxy() {
+		return host, nil
+	}
+	trusted := c.server.trustedProxies()
+	hops := strings.Split(strings.Join(c.request.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			break
+		}
+		host = hop
+		if !containsIP(trusted, ip) {
+			break
+		}
+	}
+	return host, nil
+}
+
+func (c *Context) ClientPort() (int, error) {
+	_, port, err := net.SplitHostPort(c.request.RemoteAddr)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid port in remote address '%s'", c.request.RemoteAddr)
+	}
+	return int(n), nil
+}
+
+func (c *Context) ClientAddrPort() (netip.AddrPort, error) {
+	host, _, err := net.SplitHostPort(c.request.RemoteAddr)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	port, err := c.ClientPort()
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(addr, uint16(port)), nil
+}
+
+func (c *Context) fromTrustedProxy() bool {
+	host, _, err := net.SplitHostPort(c.request.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	trusted := c.server.trustedProxies()
+	return len(trusted) > 0 && containsIP(trusted, net.ParseIP(host))
 }
 
 func (c *Context) Method() string {
@@ -58,134 +235,1240 @@ func (c *Context) URI() string {
 }
 
 func (c *Context) Path(i int) string {
-	if i >= len(c.paths) {
+	if i < 0 {
 		return ""
 	}
-	return c.paths[i]
+	p := c.suffix
+	for ; i > 0; i-- {
+		j := strings.IndexByte(p, '/')
+		if j < 0 {
+			return ""
+		}
+		p = p[j+1:]
+	}
+	if j := strings.IndexByte(p, '/'); j >= 0 {
+		p = p[:j]
+	}
+	return p
+}
+
+func (c *Context) form() url.Values {
+	if c.request.Form == nil {
+		if err := c.request.ParseForm(); err != nil {
+			c.Warningf("Failed to parse form: %s", err.Error())
+		}
+	}
+	return c.request.Form
 }
 
 func (c *Context) FormValue(name, preset string) string {
-	str := c.request.Form.Get(name)
+	str := c.form().Get(name)
 	if str == "" {
 		str = preset
 	}
 	return str
 }
 
+func (c *Context) HasFormValue(name string) bool {
+	_, ok := c.form()[name]
+	return ok
+}
+
+func (c *Context) HasQuery(name string) bool {
+	return c.request.URL.Query().Has(name)
+}
+
+func (c *Context) Header(name string) string {
+	return c.request.Header.Get(name)
+}
+
+func (c *Context) Headers() http.Header {
+	return c.request.Header.Clone()
+}
+
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+func (c *Context) ForwardableHeaders(deny ...string) http.Header {
+	h := c.request.Header.Clone()
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+	for _, name := range deny {
+		h.Del(name)
+	}
+	return h
+}
+
+func (c *Context) Cookie(name string) string {
+	cookie, err := c.request.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+var multiValueHeaders = map[string]bool{"Set-Cookie": true, "Link": true, "Www-Authenticate": true}
+
+func (c *Context) SetHeader(name, value string) {
+	if c.header == nil {
+		c.header = make(http.Header)
+	}
+	if multiValueHeaders[http.CanonicalHeaderKey(name)] {
+		c.header.Add(name, value)
+		return
+	}
+	c.header.Set(name, value)
+}
+
+func (c *Context) AddHeader(name, value string) {
+	if c.header == nil {
+		c.header = make(http.Header)
+	}
+	c.header.Add(name, value)
+}
+
+func (c *Context) SetCookie(cookie *http.Cookie) {
+	c.AddHeader("Set-Cookie", cookie.String())
+}
+
+func (c *Context) SendContinue() {
+	if expectsContinue(c.request) {
+		c.writer.WriteHeader(http.StatusContinue)
+	}
+}
+
+func (c *Context) EarlyHints(links ...string) error {
+	if c.rw.status != 0 {
+		return errors.New("Early hints must precede the response")
+	}
+	if len(links) == 0 || !c.request.ProtoAtLeast(1, 1) {
+		return nil
+	}
+	header := c.writer.Header()
+	for _, link := range links {
+		if !strings.HasPrefix(link, "<") {
+			link = "<" + link + ">; rel=preload"
+		}
+		header.Add("Link", link)
+	}
+	c.writer.WriteHeader(http.StatusEarlyHints)
+	header.Del("Link")
+	return nil
+}
+
+func (c *Context) SetTrailer(name string) {
+	if c.header == nil {
+		c.header = make(http.Header)
+	}
+	c.header.Add("Trailer", http.CanonicalHeaderKey(name))
+}
+
+func (c *Context) WriteTrailer(name, value string) {
+	if c.trailer == nil {
+		c.trailer = make(http.Header)
+	}
+	c.trailer.Set(name, value)
+}
+
+func (c *Context) flushTrailers() {
+	for k, v := range c.trailer {
+		c.writer.Header()[http.TrailerPrefix+k] = v
+	}
+}
+
+func (c *Context) SetRetryAfter(d time.Duration) {
+	c.SetHeader("Retry-After", retryAfterSeconds(d))
+}
+
+func retryAfterSeconds(d time.Duration) string {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 0 {
+		secs = 0
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
+func (c *Context) SetRetryAfterTime(t time.Time) {
+	c.SetHeader("Retry-After", t.UTC().Format(http.TimeFormat))
+}
+
+func (c *Context) Set(key string, value interface{}) {
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+	c.values[key] = value
+}
+
+func (c *Context) Get(key string) interface{} {
+	return c.values[key]
+}
+
 func (c *Context) IsTLS() bool {
 	return c.request.TLS != nil
 }
 
+func (c *Context) IsSecure() bool {
+	if c.IsTLS() {
+		return true
+	}
+	return c.fromTrustedProxy() && StrCaseEqual(c.request.Header.Get("X-Forwarded-Proto"), "https")
+}
+
 func (c *Context) Host() string {
 	return c.request.Host
 }
 
 func (c *Context) ParseJson(data interface{}) error {
 	dec := json.NewDecoder(c.request.Body)
-	return dec.Decode(data)
+	return requestError(jsonError(dec.Decode(data)))
+}
+
+func requestError(err error) error {
+	if e, ok := err.(Error); ok {
+		e.status = e.Code
+		return e
+	}
+	return err
+}
+
+func jsonError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var sizeErr *http.MaxBytesError
+	var e Error
+	switch {
+	case err == nil, err == io.EOF:
+		return err
+	case errors.As(err, &syntaxErr):
+		e = Errorf(http.StatusBadRequest, "Malformed JSON at offset %d: %s", syntaxErr.Offset, syntaxErr.Error())
+	case errors.As(err, &typeErr):
+		e = Errorf(http.StatusBadRequest, "Invalid value for '%s' at offset %d: expected %s", typeErr.Field, typeErr.Offset, typeErr.Type)
+	case err == io.ErrUnexpectedEOF:
+		e = Errorf(http.StatusBadRequest, "Truncated JSON")
+	case errors.As(err, &sizeErr):
+		e = Errorf(http.StatusRequestEntityTooLarge, "Request body exceeds %d bytes", sizeErr.Limit)
+	default:
+		return err
+	}
+	e.cause = err
+	return e
+}
+
+func (c *Context) StreamArray(fn func(dec *json.Decoder) error) error {
+	dec := json.NewDecoder(c.request.Body)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return requestError(Errorf(http.StatusBadRequest, "Request body is not a JSON array"))
+	}
+	for dec.More() {
+		if err := fn(dec); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+func (c *Context) LimitBody(n int64) {
+	c.request.Body = http.MaxBytesReader(c.writer, c.body, n)
+}
+
+func (c *Context) ResponseSize() int64 {
+	return c.rw.size
+}
+
+// Go runs fn on a new goroutine with the request context, which is canceled
+// once the request completes. Unless Server.AwaitGoroutines is set or the
+// handler calls Wait, the goroutine is detached and must not use c.
+func (c *Context) Go(fn func(ctx context.Context)) {
+	if c.tasks == nil {
+		c.tasks = new(sync.WaitGroup)
+	}
+	tasks, ctx := c.tasks, c.ctx
+	tasks.Add(1)
+	go func() {
+		defer tasks.Done()
+		fn(ctx)
+	}()
+}
+
+func (c *Context) onRelease(fn func()) {
+	c.release = append(c.release, fn)
+}
+
+func (c *Context) runRelease() {
+	for i := len(c.release) - 1; i >= 0; i-- {
+		c.release[i]()
+	}
+}
+
+func (c *Context) Wait() {
+	if c.tasks != nil {
+		c.tasks.Wait()
+	}
 }
 
 func (c *Context) SetResourceType(t string) {
 	c.resType = t
 }
 
+func (c *Context) SetResponseCode(code int) {
+	c.status = code
+}
+
+func (c *Context) DetectContentType(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+func (c *Context) AllowContentSniffing() {
+	c.sniff = true
+}
+
 func (c *Context) SetErrorResponseCode(code int) {
 	c.resCode = code
 }
 
+type NotFoundKind int
+
+const (
+	UnknownResource NotFoundKind = iota + 1
+	UnknownPath
+)
+
+func (c *Context) NotFound() NotFoundKind {
+	return c.missing
+}
+
+func (c *Context) NotFoundPath() (interface{}, error) {
+	c.missing, c.status, c.resCode = UnknownPath, http.StatusNotFound, http.StatusNotFound
+	if h := c.server.NotFoundHandler; h != nil {
+		return h(c)
+	}
+	_, rest, _ := strings.Cut(c.suffix, "/")
+	return nil, NotFoundf("No such path '%s' on '%s'", rest, c.route)
+}
+
 type Handler func(*Context) (interface{}, error)
 
+type Response struct {
+	Status      int
+	Header      http.Header
+	Body        interface{}
+	ContentType string
+}
+
 type Server struct {
-	Mux        *http.ServeMux
-	Prefix     string
+	Mux     *http.ServeMux
+	Prefix  string
+	Logger  Logger
+	Metrics Metrics
+	Debug   bool
+
+	TLSPreset TLSPreset
+	TLSConfig *tls.Config
+
+	TrustedProxies     []string
+	RequestIDGenerator func() string
+	DefaultContentType string
+	StrictNegotiation  bool
+	IndexResources     bool
+	HandleOptions      bool
+
+	MaxBodyBytes      int64
+	MaxResponseBytes  int64
+	MaxRequestTimeout time.Duration
+	MaxHeaderBytes    int
+	MaxHeaderCount    int
+	MaxBatchSize      int
+	MaxDrainBytes     int64
+	MultipartLimits   MultipartLimits
+	DrainDelay        time.Duration
+
+	AwaitGoroutines       bool
+	DisableHTMLEscape     bool
+	FieldNameMapper       func(string) string
+	CompressionThreshold  int
+	CompressionThresholds map[string]int
+	ServePrecompressed    bool
+
+	// CaseInsensitivePrefix mounts the server on the Mux root "/" so that
+	// any casing of Prefix reaches it. The server then owns every path on
+	// the Mux, and registration panics if "/" is already taken. It must be
+	// set before the first route is registered.
+	CaseInsensitivePrefix bool
+	StrictRegistration    bool
+	DisabledStatus        int
+	CaseInsensitiveRoutes bool
+	CleanPath             bool
+	RedirectCleanPath     bool
+
+	ExposeInternalErrors bool
+	DebugErrors          bool
+	PanicHandler         func(c *Context, recovered interface{}) (interface{}, error)
+	SchemaValidator      SchemaValidator
+	ResponseTransformer  func(c *Context, v interface{}) interface{}
+
+	// NotFoundHandler is wrapped in the middleware chain on the first miss
+	// and cached, so it must be set before the server starts serving.
+	NotFoundHandler Handler
+
+	// OnError is called with every error a handler returns, including
+	// recovered panics, before the response is written. c is pooled and
+	// reused once the request completes, so the hook must not keep it or
+	// use it after returning. Copy c.RequestID(), c.URI() and anything else
+	// an asynchronous reporter needs first.
+	OnError func(c *Context, err error)
+
+	ErrorFieldNames struct {
+		Code    string
+		Message string
+	}
+
+	mu         sync.RWMutex
 	registered bool
+	rooted     bool
 	handlers   map[string]Handler
+	methods    map[string]map[string]Handler
+	folded     map[string]string
+	codecs     map[string]Codec
+	gates      map[string]func() bool
+	fallback   Handler
+	docs       map[string]RouteDoc
+	middleware []Middleware
+	chains     sync.Map
+	trusted    []*net.IPNet
+	trustOnce  sync.Once
+	spaFS      fs.FS
+	spaIndex   string
+	server     *http.Server
+	shutdowns  []func(ctx context.Context) error
+	counters   serverCounters
+	draining   atomic.Bool
 }
 
 func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if s.CompressionThreshold > 0 || len(s.CompressionThresholds) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if (s.CompressionThreshold > 0 || len(s.CompressionThresholds) > 0) && acceptsGzip(r) {
+		gzw := &gzipWriter{ResponseWriter: w, threshold: s.compressionThreshold}
+		defer gzw.Close()
+		w = gzw
+	}
+	rw := &responseWriter{ResponseWriter: w, limit: s.MaxResponseBytes}
+	w = rw
+	failed := false
+	defer s.track(rw)(&failed)
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	if r.Method == "OPTIONS" {
+	if s.MaxHeaderCount > 0 && headerCount(r.Header) > s.MaxHeaderCount {
+		http.Error(w, "Too many request headers", http.StatusRequestHeaderFieldsTooLarge)
 		return
 	}
-	suffix := r.URL.Path[len(s.Prefix):]
-	splits := strings.Split(suffix, "/")
-	resource := splits[0]
-	handler := s.handlers[resource]
-	if handler == nil {
+	path := r.URL.Path
+	if s.CleanPath {
+		path = s.cleanPath(path)
+		if path != r.URL.Path && s.RedirectCleanPath && (r.Method == "GET" || r.Method == "HEAD") {
+			target := *r.URL
+			target.Path = path
+			target.RawPath = ""
+			http.Redirect(w, r, target.RequestURI(), http.StatusMovedPermanently)
+			return
+		}
+	}
+	if !s.hasPrefix(path) {
+		http.Error(w, fmt.Sprintf("Path '%s' is outside prefix '%s'", r.URL.Path, s.Prefix), http.StatusNotFound)
+		return
+	}
+	suffix := path[len(s.Prefix):]
+	first, _, _ := strings.Cut(suffix, "/")
+	resource, m := s.match(first, r.Method)
+	if m.disabled {
+		http.Error(w, fmt.Sprintf("Resource '%s' is disabled", resource), s.DisabledStatus)
+		return
+	}
+	if !m.known && s.serveSPA(w, r) {
+		return
+	}
+	if !m.known && m.handler == nil {
 		http.Error(w, fmt.Sprintf("No such resource '%s'", resource), http.StatusNotFound)
 		return
 	}
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if m.options {
+		w.Header().Set("Allow", m.allow)
+		w.Header().Set("Access-Control-Allow-Methods", m.allow)
+		return
+	}
+	if m.handler == nil {
+		w.Header().Set("Allow", m.allow)
+		http.Error(w, fmt.Sprintf("Method %s not allowed on '%s'", r.Method, resource), http.StatusMethodNotAllowed)
+		return
+	}
+	handler := m.handler
+	body := r.Body
+	if s.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, body, s.MaxBodyBytes)
+	}
+	if isFormType(r.Header.Get("Content-Type")) && !expectsContinue(r) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	rctx := r.Context()
+	if s.MaxRequestTimeout > 0 {
+		if d, err := time.ParseDuration(r.Header.Get("X-Request-Timeout")); err == nil && d > 0 {
+			if d > s.MaxRequestTimeout {
+				d = s.MaxRequestTimeout
+			}
+			var cancel context.CancelFunc
+			rctx, cancel = context.WithTimeout(rctx, d)
+			defer cancel()
+		}
+	}
+	resType, acceptable := s.negotiate(r)
+	if !acceptable && s.StrictNegotiation {
+		http.Error(w, "Not acceptable, available types: "+strings.Join(s.available(), ", "), http.StatusNotAcceptable)
+		return
+	}
+	charsetOK := acceptsUTF8(r.Header.Get("Accept-Charset"))
+	if isTextType(resType) && !charsetOK {
+		http.Error(w, "Only utf-8 charset is available", http.StatusNotAcceptable)
 		return
 	}
 	var err error
-	ctx := &Context{request: r, paths: splits, resType: "application/json", resCode: -1}
-	res, err := handler(ctx)
-	io.Copy(ioutil.Discard, r.Body)
+	ctx := acquireContext()
+	defer releaseContext(ctx)
+	defer ctx.runRelease()
+	if s.AwaitGoroutines {
+		defer ctx.Wait()
+	}
+	ctx.server, ctx.writer, ctx.rw, ctx.request, ctx.body, ctx.ctx = s, w, rw, r, body, rctx
+	ctx.reqID, ctx.route, ctx.suffix, ctx.resType, ctx.resCode = r.Header.Get("X-Request-Id"), resource, suffix, resType, -1
+	ctx.start = start
+	defer ctx.recordResponse()
+	if m.missing != 0 {
+		ctx.missing, ctx.status, ctx.resCode = m.missing, http.StatusNotFound, http.StatusNotFound
+	}
+	defer ctx.flushTrailers()
+	defer removeMultipart(r)
+	res, err := s.invoke(ctx, handler)
+	s.drain(w, r)
+	for k, v := range ctx.header {
+		w.Header()[k] = v
+	}
+	if ctx.sniff {
+		w.Header().Del("X-Content-Type-Options")
+	}
+	failed = err != nil
+	if err != nil && s.OnError != nil {
+		s.OnError(ctx, err)
+	}
+	if err != nil && rw.status != 0 {
+		ctx.Errorf("Handler error after response was written: %s", err.Error())
+		return
+	}
+	if err != nil && ctx.ctx.Err() == context.DeadlineExceeded {
+		ctx.Warningf("Deadline exceeded: %s", err.Error())
+		http.Error(w, "Request timeout exceeded", http.StatusGatewayTimeout)
+		return
+	}
 	if err != nil {
 		switch err.(type) {
 		case Error:
-			ctx.Warningf("%s %s restful error: %d %s", r.Method, r.URL.Path, err.(Error).Code, err.Error())
-			res = err
+			ctx.Warningf("Restful error: %d %s", err.(Error).Code, err.Error())
+			res = s.errorBody(err.(Error))
+			if e := err.(Error); e.Retryable && e.RetryAfter > 0 {
+				w.Header().Set("Retry-After", retryAfterSeconds(e.RetryAfter))
+			}
+			ctx.status = err.(Error).status
+			if ctx.resCode != -1 {
+				ctx.status = ctx.resCode
+			}
+			if !s.canEncode(ctx.resType, res) {
+				ctx.resType = "application/json"
+			}
 		default:
-			ctx.Warningf("%s %s error: %s", r.Method, r.URL.Path, err.Error())
+			ctx.Warningf("Handler error: %s", err.Error())
 			code := http.StatusInternalServerError
 			if ctx.resCode != -1 {
 				code = ctx.resCode
 			}
-			http.Error(w, err.Error(), code)
-			return
+			if s.ExposeInternalErrors {
+				http.Error(w, err.Error(), code)
+				return
+			}
+			res = s.debugErrorBody(Errorf(code, "%s", http.StatusText(code)), err)
+			ctx.status = code
+			ctx.resType = "application/json"
+		}
+	}
+	status := ctx.status
+	if resp, ok := res.(*Response); ok {
+		res = *resp
+	}
+	if resp, ok := res.(Response); ok {
+		for k, v := range resp.Header {
+			w.Header()[k] = v
 		}
+		if resp.ContentType != "" {
+			ctx.resType = resp.ContentType
+		}
+		if resp.Status != 0 {
+			status = resp.Status
+		}
+		res = resp.Body
+	}
+	if err == nil && status < 400 && s.ResponseTransformer != nil && transformable(res) {
+		res = s.ResponseTransformer(ctx, res)
+	}
+	if ctx.resType != resType && isTextType(ctx.resType) && !charsetOK {
+		http.Error(w, "Only utf-8 charset is available", http.StatusNotAcceptable)
+		return
+	}
+	if ch, ok := streamChannel(res); ok {
+		s.stream(ctx, w, ch, status)
+		return
 	}
 	w.Header().Set("Content-Type", ctx.resType)
-	if ctx.resType == "application/json" {
+	if reader, ok := res.(io.Reader); ok {
+		if closer, ok := reader.(io.Closer); ok {
+			defer closer.Close()
+		}
+		if status != 0 {
+			w.WriteHeader(status)
+		}
+		if _, err := io.Copy(w, reader); err != nil {
+			rw.writeFailed(ctx, err)
+		}
+		return
+	}
+	if err := r.Context().Err(); err != nil {
+		ctx.Debugf("Client gone before response was written: %s", err.Error())
+		ctx.count("client_gone")
+		return
+	}
+	if isJSONType(ctx.resType) {
 		if res == nil {
 			res = make(map[string]interface{})
 		}
-		enc := json.NewEncoder(w)
+		enc := acquireEncoder()
+		defer releaseEncoder(enc)
+		enc.SetEscapeHTML(!s.DisableHTMLEscape)
+		if s.FieldNameMapper != nil {
+			res = mapFieldNames(reflect.ValueOf(res), s.FieldNameMapper)
+		}
 		// enc.SetIndent("", "    ")
 		if err = enc.Encode(res); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	} else {
-		if isByteArray(res) == false {
-			ctx.Errorf("Resource is not byte array.")
-			http.Error(w, "", http.StatusInternalServerError)
+			ctx.Errorf("Failed to encode json: %s", err.Error())
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 			return
 		}
-		bytes := res.([]byte)
-		off := 0
-		for off < len(bytes) {
-			n, err := w.Write(res.([]byte))
-			if err != nil {
-				ctx.Errorf("Failed to write byttes: %s", err.Error())
+		res = enc.buf.Bytes()
+	} else if codec := s.codec(ctx.resType); codec != nil {
+		data, err := codec.Marshal(res)
+		if err != nil {
+			ctx.Errorf("Failed to marshal %s: %s", ctx.resType, err.Error())
+			if s.ExposeInternalErrors {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			off = off + n
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			return
+		}
+		res = data
+	}
+	var data []byte
+	switch v := res.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		ctx.Errorf("Resource of type %T cannot be written as %s.", res, ctx.resType)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	if w.Header().Get("Trailer") == "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+	off := 0
+	for off < len(data) {
+		n, err := w.Write(data[off:])
+		if err == nil && n == 0 {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			rw.writeFailed(ctx, err)
+			return
+		}
+		off = off + n
+	}
+}
+
+// Contexts are recycled once serveHTTP returns. Goroutines started with c.Go,
+// goroutines feeding a streamed channel and anything else that outlives the
+// handler must copy what they need up front and not touch c afterwards.
+var contextPool = sync.Pool{New: func() interface{} { return new(Context) }}
+
+func acquireContext() *Context {
+	return contextPool.Get().(*Context)
+}
+
+func releaseContext(c *Context) {
+	header, values := c.header, c.values
+	for k := range header {
+		delete(header, k)
+	}
+	for k := range values {
+		delete(values, k)
+	}
+	*c = Context{header: header, values: values}
+	contextPool.Put(c)
+}
+
+type pooledEncoder struct {
+	*json.Encoder
+	buf bytes.Buffer
+}
+
+const maxPooledBuffer = 64 << 10
+
+var encoderPool = sync.Pool{New: func() interface{} {
+	e := new(pooledEncoder)
+	e.Encoder = json.NewEncoder(&e.buf)
+	return e
+}}
+
+func acquireEncoder() *pooledEncoder {
+	return encoderPool.Get().(*pooledEncoder)
+}
+
+func releaseEncoder(e *pooledEncoder) {
+	if e.buf.Cap() > maxPooledBuffer {
+		return
+	}
+	e.buf.Reset()
+	encoderPool.Put(e)
+}
+
+func transformable(res interface{}) bool {
+	switch res.(type) {
+	case []byte, string, io.Reader:
+		return false
+	}
+	_, stream := streamChannel(res)
+	return !stream
+}
+
+func removeMultipart(r *http.Request) {
+	if r.MultipartForm != nil {
+		r.MultipartForm.RemoveAll()
+	}
+}
+
+func expectsContinue(r *http.Request) bool {
+	return StrCaseEqual(r.Header.Get("Expect"), "100-continue")
+}
+
+func (s *Server) drain(w http.ResponseWriter, r *http.Request) {
+	if expectsContinue(r) {
+		return
+	}
+	if s.MaxDrainBytes <= 0 {
+		io.Copy(ioutil.Discard, r.Body)
+		return
+	}
+	if n, _ := io.CopyN(ioutil.Discard, r.Body, s.MaxDrainBytes+1); n > s.MaxDrainBytes {
+		w.Header().Set("Connection", "close")
+	}
+}
+
+func (s *Server) resolve(resource string) string {
+	if !s.CaseInsensitiveRoutes {
+		return resource
+	}
+	if _, ok := s.handlers[resource]; ok {
+		return resource
+	}
+	if _, ok := s.methods[resource]; ok {
+		return resource
+	}
+	if name, ok := s.folded[strings.ToLower(resource)]; ok {
+		return name
+	}
+	return resource
+}
+
+func (s *Server) foldRoute(resource string) {
+	if s.folded == nil {
+		s.folded = make(map[string]string)
+	}
+	key := strings.ToLower(resource)
+	if _, ok := s.folded[key]; !ok {
+		s.folded[key] = resource
+	}
+}
+
+func (s *Server) unfoldRoute(resource string) {
+	key := strings.ToLower(resource)
+	if s.folded[key] != resource {
+		return
+	}
+	delete(s.folded, key)
+	for name := range s.handlers {
+		if strings.ToLower(name) == key {
+			s.folded[key] = name
+			return
+		}
+	}
+	for name := range s.methods {
+		if strings.ToLower(name) == key {
+			s.folded[key] = name
+			return
 		}
 	}
 }
 
-var typeOfBytes = reflect.TypeOf([]byte(nil))
+func (s *Server) cleanPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && i > 0 && path[i-1] == '/' {
+			continue
+		}
+		b.WriteByte(path[i])
+	}
+	cleaned := b.String()
+	for len(cleaned) > len(s.Prefix) && len(cleaned) > 1 && strings.HasSuffix(cleaned, "/") {
+		cleaned = cleaned[:len(cleaned)-1]
+	}
+	return cleaned
+}
+
+func (s *Server) hasPrefix(path string) bool {
+	if len(path) < len(s.Prefix) {
+		return false
+	}
+	if s.CaseInsensitivePrefix {
+		return StrCaseEqual(path[:len(s.Prefix)], s.Prefix)
+	}
+	return path[:len(s.Prefix)] == s.Prefix
+}
+
+func (s *Server) trustedProxies() []*net.IPNet {
+	s.trustOnce.Do(func() {
+		trusted, err := parseCIDRs(s.TrustedProxies)
+		if err != nil {
+			s.logger().Printf("Invalid TrustedProxies: %s", err.Error())
+			return
+		}
+		s.trusted = trusted
+	})
+	return s.trusted
+}
+
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
 
-func isByteArray(a interface{}) bool {
-	v := reflect.ValueOf(a)
-	return v.Kind() == reflect.Slice && v.Type() == typeOfBytes
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Handler panic: %v", e.Value)
+}
+
+func recoverPanics(handler Handler) Handler {
+	return func(c *Context) (res interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				res, err = nil, &PanicError{Value: p, Stack: debug.Stack()}
+			}
+		}()
+		return handler(c)
+	}
+}
+
+func (s *Server) invoke(c *Context, handler Handler) (res interface{}, err error) {
+	defer func() {
+		var pe *PanicError
+		if !errors.As(err, &pe) {
+			return
+		}
+		c.Errorf("%s", pe.Error())
+		if s.OnError != nil {
+			s.OnError(c, pe)
+		}
+		if s.PanicHandler != nil {
+			res, err = s.PanicHandler(c, pe.Value)
+			return
+		}
+		res = Response{Status: http.StatusInternalServerError, Body: s.debugErrorBody(Errorf(http.StatusInternalServerError, "Internal server error"), pe)}
+		err = nil
+	}()
+	return recoverPanics(handler)(c)
+}
+
+type routeMatch struct {
+	handler  Handler
+	known    bool
+	disabled bool
+	options  bool
+	allow    string
+	missing  NotFoundKind
+}
+
+func (s *Server) match(name, method string) (string, routeMatch) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var m routeMatch
+	resource := s.resolve(name)
+	handler := s.handlers[resource]
+	methods := s.methods[resource]
+	if resource == "" && handler == nil && methods == nil && s.IndexResources {
+		handler = s.index
+	}
+	m.known = handler != nil || methods != nil
+	if gate := s.gates[resource]; m.known && gate != nil && !gate() {
+		if s.DisabledStatus != 0 {
+			m.disabled = true
+			return resource, m
+		}
+		m.known = false
+	}
+	if !m.known {
+		if s.fallback != nil {
+			m.handler = s.compiled(defaultChainKey, s.fallback)
+		} else if s.NotFoundHandler != nil {
+			m.handler, m.missing = s.compiled(notFoundChainKey, s.NotFoundHandler), UnknownResource
+		}
+		return resource, m
+	}
+	if method == "OPTIONS" && !(s.HandleOptions && (handler != nil || methods["OPTIONS"] != nil)) {
+		m.options = true
+		m.allow = s.allowedMethods(resource)
+		return resource, m
+	}
+	key := chainKey{resource: resource}
+	if h := methods[method]; h != nil {
+		handler = h
+		key.method = method
+	}
+	if handler == nil {
+		m.allow = s.allowedMethods(resource)
+		return resource, m
+	}
+	m.handler = s.compiled(key, handler)
+	return resource, m
+}
+
+type chainKey struct {
+	method   string
+	resource string
+}
+
+var (
+	defaultChainKey  = chainKey{method: "*"}
+	notFoundChainKey = chainKey{method: "404"}
+)
+
+func (s *Server) compiled(key chainKey, handler Handler) Handler {
+	if h, ok := s.chains.Load(key); ok {
+		return h.(Handler)
+	}
+	h := chain(recoverPanics(handler), s.middleware)
+	s.chains.Store(key, h)
+	return h
+}
+
+func (s *Server) resetChains() {
+	s.chains.Range(func(key, _ interface{}) bool {
+		s.chains.Delete(key)
+		return true
+	})
+}
+
+func (s *Server) errorBody(e Error) interface{} {
+	if s.ErrorFieldNames.Code == "" && s.ErrorFieldNames.Message == "" {
+		return e
+	}
+	return s.errorMap(e)
+}
+
+func (s *Server) errorMap(e Error) map[string]interface{} {
+	names := s.ErrorFieldNames
+	if names.Code == "" {
+		names.Code = "error"
+	}
+	if names.Message == "" {
+		names.Message = "reason"
+	}
+	body := map[string]interface{}{names.Code: e.Code, names.Message: e.Reason}
+	if e.Type != "" {
+		body["type"] = e.Type
+	}
+	if e.Retryable {
+		body["retryable"] = true
+	}
+	return body
+}
+
+func (s *Server) debugErrorBody(e Error, cause error) interface{} {
+	if !s.DebugErrors {
+		return s.errorBody(e)
+	}
+	var chain []string
+	for err := cause; err != nil; err = errors.Unwrap(err) {
+		chain = append(chain, err.Error())
+	}
+	info := map[string]interface{}{"errors": chain}
+	var pe *PanicError
+	if errors.As(cause, &pe) {
+		info["stack"] = string(pe.Stack)
+	}
+	body := s.errorMap(e)
+	body["debug"] = info
+	return body
+}
+
+func (s *Server) canEncode(contentType string, v interface{}) bool {
+	if isJSONType(contentType) {
+		return true
+	}
+	codec := s.codec(contentType)
+	if codec == nil {
+		return false
+	}
+	_, err := codec.Marshal(v)
+	return err == nil
+}
+
+func isJSONType(t string) bool {
+	media, _, err := mime.ParseMediaType(t)
+	if err != nil {
+		return false
+	}
+	return media == "application/json" || strings.HasSuffix(media, "+json")
+}
+
+func isFormType(t string) bool {
+	media, _, err := mime.ParseMediaType(t)
+	return err == nil && media == "application/x-www-form-urlencoded"
+}
+
+func headerCount(h http.Header) int {
+	n := 0
+	for _, v := range h {
+		n += len(v)
+	}
+	return n
+}
+
+func (s *Server) Register(resource string, handler Handler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.handlers[resource]; ok {
+		return fmt.Errorf("Resource '%s' already registered", resource)
+	}
+	s.register(resource, handler)
+	return nil
 }
 
 func (s *Server) HandleFunc(resource string, handler Handler) {
-	if !s.registered {
-		s.Mux.HandleFunc(s.Prefix, s.serveHTTP)
-		s.registered = true
+	if s.StrictRegistration {
+		if err := s.Register(resource, handler); err != nil {
+			panic(err)
+		}
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.register(resource, handler)
+}
+
+func (s *Server) Unregister(resource string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resetChains()
+	delete(s.handlers, resource)
+	delete(s.methods, resource)
+	s.unfoldRoute(resource)
+	delete(s.gates, resource)
+	delete(s.docs, resource)
+}
+
+func (s *Server) HandleFuncAliases(handler Handler, resources ...string) {
+	for _, resource := range resources {
+		s.HandleFunc(resource, handler)
 	}
+}
+
+func (s *Server) RegisterMethod(method, resource string, handler Handler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.methods[resource][method]; ok {
+		return fmt.Errorf("Method %s on resource '%s' already registered", method, resource)
+	}
+	s.registerMethod(method, resource, handler)
+	return nil
+}
+
+func (s *Server) HandleMethod(method, resource string, handler Handler) {
+	if s.StrictRegistration {
+		if err := s.RegisterMethod(method, resource, handler); err != nil {
+			panic(err)
+		}
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registerMethod(method, resource, handler)
+}
+
+func (s *Server) registerMethod(method, resource string, handler Handler) {
+	s.mount()
+	s.resetChains()
+	if s.methods == nil {
+		s.methods = make(map[string]map[string]Handler)
+	}
+	if s.methods[resource] == nil {
+		s.methods[resource] = make(map[string]Handler)
+	}
+	s.methods[resource][method] = handler
+	s.foldRoute(resource)
+}
+
+func (s *Server) allowedMethods(resource string) string {
+	if s.handlers[resource] != nil {
+		return "GET, POST, PUT, DELETE, OPTIONS"
+	}
+	methods := make([]string, 0, len(s.methods[resource])+1)
+	for method := range s.methods[resource] {
+		if method != "OPTIONS" {
+			methods = append(methods, method)
+		}
+	}
+	sort.Strings(methods)
+	return strings.Join(append(methods, "OPTIONS"), ", ")
+}
+
+func (s *Server) HandleIndex(handler Handler) {
+	s.HandleFunc("", handler)
+}
+
+func (s *Server) HandleDefault(handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mount()
+	s.resetChains()
+	s.fallback = handler
+}
+
+func (s *Server) index(c *Context) (interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	resources := make([]string, 0, len(s.handlers)+len(s.methods))
+	for resource, handler := range s.handlers {
+		if resource != "" && handler != nil {
+			resources = append(resources, resource)
+		}
+	}
+	for resource := range s.methods {
+		if _, ok := s.handlers[resource]; !ok && resource != "" {
+			resources = append(resources, resource)
+		}
+	}
+	sort.Strings(resources)
+	return map[string]interface{}{"resources": resources}, nil
+}
+
+func (s *Server) HandleFuncIf(enabled bool, resource string, handler Handler) {
+	s.HandleFunc(resource, handler)
+	s.FeatureGate(resource, func() bool { return enabled })
+}
+
+func (s *Server) FeatureGate(resource string, fn func() bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gates == nil {
+		s.gates = make(map[string]func() bool)
+	}
+	s.gates[resource] = fn
+}
+
+func (s *Server) mount() {
+	if s.registered {
+		if s.CaseInsensitivePrefix != s.rooted {
+			panic("CaseInsensitivePrefix changed after the first route was registered")
+		}
+		return
+	}
+	pattern := s.Prefix
+	if s.CaseInsensitivePrefix {
+		pattern = "/"
+	}
+	s.Mux.HandleFunc(pattern, s.serveHTTP)
+	s.registered, s.rooted = true, s.CaseInsensitivePrefix
+}
+
+func (s *Server) register(resource string, handler Handler) {
+	s.mount()
+	s.resetChains()
 	if s.handlers == nil {
 		s.handlers = make(map[string]Handler)
 	}
 	s.handlers[resource] = handler
+	s.foldRoute(resource)
+}
+
+func (s *Server) newServer(addr string) *http.Server {
+	s.server = &http.Server{Addr: addr, Handler: s.Mux, MaxHeaderBytes: s.MaxHeaderBytes, TLSConfig: s.tlsConfig()}
+	return s.server
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	return s.newServer(addr).ListenAndServe()
+}
+
+func (s *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
+	return s.newServer(addr).ListenAndServeTLS(certFile, keyFile)
+}
+
+func (s *Server) OnShutdown(fn func(ctx context.Context) error) {
+	s.shutdowns = append(s.shutdowns, fn)
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	if s.DrainDelay > 0 {
+		timer := time.NewTimer(s.DrainDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+	var errs []error
+	if s.server != nil {
+		if err := s.server.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, fn := range s.shutdowns {
+		if err := fn(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,1890 @@
+package iorest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newTestServer() *Server {
+	return &Server{Mux: http.NewServeMux(), Prefix: "/api/", Logger: log.New(io.Discard, "", 0)}
+}
+
+func TestLimitBody(t *testing.T) {
+	s := newTestServer()
+	s.MaxBodyBytes = 16
+	read := func(c *Context) (interface{}, error) {
+		data, err := ioutil.ReadAll(c.request.Body)
+		if err != nil {
+			c.SetErrorResponseCode(http.StatusRequestEntityTooLarge)
+			return nil, Errorf(http.StatusRequestEntityTooLarge, "%s", err.Error())
+		}
+		return len(data), nil
+	}
+	s.HandleFunc("json", read)
+	s.HandleFunc("upload", func(c *Context) (interface{}, error) {
+		c.LimitBody(64)
+		return read(c)
+	})
+	s.HandleFunc("tiny", func(c *Context) (interface{}, error) {
+		c.LimitBody(4)
+		return read(c)
+	})
+	tests := []struct {
+		resource string
+		size     int
+		code     int
+	}{
+		{"json", 16, http.StatusOK},
+		{"json", 32, http.StatusRequestEntityTooLarge},
+		{"upload", 32, http.StatusOK},
+		{"upload", 65, http.StatusRequestEntityTooLarge},
+		{"tiny", 4, http.StatusOK},
+		{"tiny", 8, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		res, err := s.ServeTest("POST", "/api/"+tt.resource, strings.NewReader(strings.Repeat("x", tt.size)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Code != tt.code {
+			t.Errorf("%s with %d bytes: got %d, want %d", tt.resource, tt.size, res.Code, tt.code)
+		}
+	}
+}
+
+func TestOnShutdownOrder(t *testing.T) {
+	s := newTestServer()
+	var order []int
+	errA, errB := errors.New("a"), errors.New("b")
+	s.OnShutdown(func(ctx context.Context) error {
+		order = append(order, 1)
+		return errA
+	})
+	s.OnShutdown(func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("Hook context has no deadline")
+		}
+		order = append(order, 2)
+		return nil
+	})
+	s.OnShutdown(func(ctx context.Context) error {
+		order = append(order, 3)
+		return errB
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Errorf("Hooks ran in order %v", order)
+	}
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("Shutdown error %v does not aggregate hook errors", err)
+	}
+}
+
+func TestStrictRegistration(t *testing.T) {
+	s := newTestServer()
+	s.StrictRegistration = true
+	noop := func(c *Context) (interface{}, error) { return nil, nil }
+	s.HandleFunc("users", noop)
+	if err := s.Register("users", noop); err == nil {
+		t.Error("Duplicate Register returned no error")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Duplicate HandleFunc did not panic")
+			}
+		}()
+		s.HandleFunc("users", noop)
+	}()
+	s.HandleMethod("GET", "items", noop)
+	s.HandleMethod("POST", "items", noop)
+	if err := s.RegisterMethod("GET", "items", noop); err == nil {
+		t.Error("Duplicate RegisterMethod returned no error")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Duplicate HandleMethod did not panic")
+			}
+		}()
+		s.HandleMethod("POST", "items", noop)
+	}()
+
+	lenient := newTestServer()
+	lenient.HandleFunc("users", noop)
+	lenient.HandleFunc("users", func(c *Context) (interface{}, error) { return "second", nil })
+	res, err := lenient.ServeTest("GET", "/api/users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Value != "second" {
+		t.Errorf("Lenient re-registration served %v", res.Value)
+	}
+}
+
+func TestRequestTimeoutHeader(t *testing.T) {
+	s := newTestServer()
+	s.MaxRequestTimeout = 50 * time.Millisecond
+	s.HandleFunc("budget", func(c *Context) (interface{}, error) {
+		d, ok := c.TimeRemaining()
+		if !ok {
+			return "none", nil
+		}
+		return d.String(), nil
+	})
+	s.HandleFunc("slow", func(c *Context) (interface{}, error) {
+		<-c.Context().Done()
+		return nil, c.Context().Err()
+	})
+	serve := func(resource, timeout string) *TestResponse {
+		r := httptest.NewRequest("GET", "/api/"+resource, nil)
+		if timeout != "" {
+			r.Header.Set("X-Request-Timeout", timeout)
+		}
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, r)
+		return &TestResponse{Code: w.Code, Body: w.Body.Bytes()}
+	}
+	remaining := func(body []byte) time.Duration {
+		d, err := time.ParseDuration(strings.Trim(strings.TrimSpace(string(body)), `"`))
+		if err != nil {
+			t.Fatalf("Unexpected budget %s", body)
+		}
+		return d
+	}
+	if d := remaining(serve("budget", "20ms").Body); d <= 0 || d > 20*time.Millisecond {
+		t.Errorf("Valid timeout gave budget %s", d)
+	}
+	if d := remaining(serve("budget", "1h").Body); d <= 0 || d > 50*time.Millisecond {
+		t.Errorf("Over-max timeout was not clamped: %s", d)
+	}
+	if body := strings.TrimSpace(string(serve("budget", "soon").Body)); body != `"none"` {
+		t.Errorf("Malformed timeout was not ignored: %s", body)
+	}
+	if res := serve("slow", "10ms"); res.Code != http.StatusGatewayTimeout {
+		t.Errorf("Exceeded deadline gave %d, want 504", res.Code)
+	}
+}
+
+func TestFeatureGates(t *testing.T) {
+	s := newTestServer()
+	ok := func(c *Context) (interface{}, error) { return "ok", nil }
+	s.HandleFuncIf(true, "enabled", ok)
+	s.HandleFuncIf(false, "disabled", ok)
+	var beta atomic.Bool
+	s.HandleFunc("beta", ok)
+	s.FeatureGate("beta", beta.Load)
+	code := func(resource string) int {
+		res, err := s.ServeTest("GET", "/api/"+resource, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Code
+	}
+	if c := code("enabled"); c != http.StatusOK {
+		t.Errorf("Enabled route gave %d", c)
+	}
+	if c := code("disabled"); c != http.StatusNotFound {
+		t.Errorf("Disabled route gave %d, want 404", c)
+	}
+	if c := code("beta"); c != http.StatusNotFound {
+		t.Errorf("Gated route gave %d before toggle", c)
+	}
+	beta.Store(true)
+	if c := code("beta"); c != http.StatusOK {
+		t.Errorf("Gated route gave %d after toggle", c)
+	}
+	s.DisabledStatus = http.StatusServiceUnavailable
+	if c := code("disabled"); c != http.StatusServiceUnavailable {
+		t.Errorf("Disabled route gave %d, want configured 503", c)
+	}
+}
+
+func TestOptionsAllow(t *testing.T) {
+	s := newTestServer()
+	noop := func(c *Context) (interface{}, error) { return nil, nil }
+	s.HandleMethod("GET", "report", noop)
+	for _, method := range []string{"GET", "POST", "PUT", "DELETE"} {
+		s.HandleMethod(method, "items", noop)
+	}
+	tests := []struct {
+		resource string
+		code     int
+		allow    string
+	}{
+		{"report", http.StatusOK, "GET, OPTIONS"},
+		{"items", http.StatusOK, "DELETE, GET, POST, PUT, OPTIONS"},
+		{"unknown", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		res, err := s.ServeTest("OPTIONS", "/api/"+tt.resource, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Code != tt.code || res.Header.Get("Allow") != tt.allow {
+			t.Errorf("OPTIONS %s: got %d %q, want %d %q", tt.resource, res.Code, res.Header.Get("Allow"), tt.code, tt.allow)
+		}
+		if tt.allow != "" && res.Header.Get("Access-Control-Allow-Methods") != tt.allow {
+			t.Errorf("OPTIONS %s: preflight methods %q", tt.resource, res.Header.Get("Access-Control-Allow-Methods"))
+		}
+	}
+}
+
+func TestResponseValue(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("created", func(c *Context) (interface{}, error) {
+		return Response{
+			Status: http.StatusCreated,
+			Header: http.Header{"Location": {"/api/created/1"}},
+			Body:   map[string]int{"id": 1},
+		}, nil
+	})
+	s.HandleFunc("text", func(c *Context) (interface{}, error) {
+		return &Response{Status: http.StatusAccepted, ContentType: "text/plain", Body: "queued"}, nil
+	})
+	s.HandleFunc("plain", func(c *Context) (interface{}, error) {
+		return map[string]int{"id": 2}, nil
+	})
+	res, err := s.ServeTest("POST", "/api/created", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != http.StatusCreated || res.Header.Get("Location") != "/api/created/1" {
+		t.Errorf("Got %d, Location %q", res.Code, res.Header.Get("Location"))
+	}
+	if v, _ := res.Value.(map[string]interface{}); v["id"] != 1.0 {
+		t.Errorf("Body %s", res.Body)
+	}
+	res, _ = s.ServeTest("POST", "/api/text", nil)
+	if res.Code != http.StatusAccepted || res.Header.Get("Content-Type") != "text/plain" || string(res.Body) != "queued" {
+		t.Errorf("Got %d %s %q", res.Code, res.Header.Get("Content-Type"), res.Body)
+	}
+	res, _ = s.ServeTest("GET", "/api/plain", nil)
+	if res.Code != http.StatusOK || strings.TrimSpace(string(res.Body)) != `{"id":2}` {
+		t.Errorf("Plain value gave %d %s", res.Code, res.Body)
+	}
+}
+
+func TestErrorFieldNames(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("fail", func(c *Context) (interface{}, error) {
+		return nil, Errorf(http.StatusConflict, "Already exists")
+	})
+	res, err := s.ServeTest("GET", "/api/fail", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(res.Body)); got != `{"error":409,"reason":"Already exists"}` {
+		t.Errorf("Default error body %s", got)
+	}
+	s.ErrorFieldNames.Code = "code"
+	s.ErrorFieldNames.Message = "message"
+	res, _ = s.ServeTest("GET", "/api/fail", nil)
+	if got := strings.TrimSpace(string(res.Body)); got != `{"code":409,"message":"Already exists"}` {
+		t.Errorf("Renamed error body %s", got)
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("boom", func(c *Context) (interface{}, error) {
+		panic("kaboom")
+	})
+	res, err := s.ServeTest("GET", "/api/boom", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != http.StatusInternalServerError || strings.Contains(string(res.Body), "kaboom") {
+		t.Errorf("Default recovery gave %d %s", res.Code, res.Body)
+	}
+	s.PanicHandler = func(c *Context, recovered interface{}) (interface{}, error) {
+		c.SetResponseCode(http.StatusServiceUnavailable)
+		return map[string]interface{}{"panic": recovered, "route": c.Path(0)}, nil
+	}
+	res, _ = s.ServeTest("GET", "/api/boom", nil)
+	v, _ := res.Value.(map[string]interface{})
+	if res.Code != http.StatusServiceUnavailable || v["panic"] != "kaboom" || v["route"] != "boom" {
+		t.Errorf("Custom panic handler gave %d %s", res.Code, res.Body)
+	}
+}
+
+func TestErrorAfterStagedCode(t *testing.T) {
+	s := newTestServer()
+	logger := &captureLogger{}
+	s.Logger = logger
+	s.HandleFunc("staged", func(c *Context) (interface{}, error) {
+		c.SetResponseCode(http.StatusCreated)
+		return nil, Errorf(http.StatusConflict, "Duplicate")
+	})
+	s.HandleFunc("coded", func(c *Context) (interface{}, error) {
+		c.SetResponseCode(http.StatusCreated)
+		c.SetErrorResponseCode(http.StatusConflict)
+		return nil, Errorf(http.StatusConflict, "Duplicate")
+	})
+	s.HandleFunc("written", func(c *Context) (interface{}, error) {
+		c.writer.WriteHeader(http.StatusAccepted)
+		c.writer.Write([]byte("partial"))
+		return nil, Errorf(http.StatusConflict, "Too late")
+	})
+	res, _ := s.ServeTest("POST", "/api/staged", nil)
+	if res.Code != http.StatusOK || !strings.Contains(string(res.Body), "Duplicate") {
+		t.Errorf("Staged code leaked into error response: %d %s", res.Code, res.Body)
+	}
+	res, _ = s.ServeTest("POST", "/api/coded", nil)
+	if res.Code != http.StatusConflict {
+		t.Errorf("Error response code was not applied: %d", res.Code)
+	}
+	res, _ = s.ServeTest("POST", "/api/written", nil)
+	if res.Code != http.StatusAccepted || string(res.Body) != "partial" {
+		t.Errorf("Written response was altered: %d %q", res.Code, res.Body)
+	}
+	found := false
+	for _, line := range logger.output() {
+		found = found || strings.Contains(line, "Handler error after response was written: Too late")
+	}
+	if !found {
+		t.Errorf("Write-then-error was not logged: %q", logger.output())
+	}
+}
+
+func TestDefaultContentType(t *testing.T) {
+	s := newTestServer()
+	s.DefaultContentType = "text/plain"
+	s.HandleFunc("hello", func(c *Context) (interface{}, error) {
+		return "hello", nil
+	})
+	s.HandleFunc("json", func(c *Context) (interface{}, error) {
+		c.SetResourceType("application/json")
+		return map[string]int{"n": 1}, nil
+	})
+	s.HandleFunc("fail", func(c *Context) (interface{}, error) {
+		return nil, Errorf(http.StatusConflict, "Conflict")
+	})
+	s.HandleFunc("proto", func(c *Context) (interface{}, error) {
+		c.SetResourceType("application/x-protobuf")
+		if c.FormValue("ok", "") == "" {
+			return nil, Errorf(http.StatusBadRequest, "Bad message")
+		}
+		return map[string]int{"not": 1}, nil
+	})
+	tests := []struct {
+		path string
+		code int
+		typ  string
+		body string
+	}{
+		{"/api/hello", http.StatusOK, "text/plain", "hello"},
+		{"/api/json", http.StatusOK, "application/json", `{"n":1}` + "\n"},
+		{"/api/fail", http.StatusOK, "application/json", `{"error":409,"reason":"Conflict"}` + "\n"},
+		{"/api/proto", http.StatusOK, "application/json", `{"error":400,"reason":"Bad message"}` + "\n"},
+		{"/api/proto?ok=1", http.StatusInternalServerError, "text/plain; charset=utf-8", "Failed to encode response\n"},
+	}
+	for _, tt := range tests {
+		res, _ := s.ServeTest("GET", tt.path, nil)
+		if res.Code != tt.code || res.Header.Get("Content-Type") != tt.typ || string(res.Body) != tt.body {
+			t.Errorf("%s: got %d %q %q, want %d %q %q", tt.path, res.Code, res.Header.Get("Content-Type"), res.Body, tt.code, tt.typ, tt.body)
+		}
+	}
+}
+
+func TestMaxHeaderCount(t *testing.T) {
+	s := newTestServer()
+	s.MaxHeaderCount = 5
+	s.MaxHeaderBytes = 4096
+	s.HandleFunc("ping", func(c *Context) (interface{}, error) {
+		return "pong", nil
+	})
+	req := httptest.NewRequest("GET", "/api/ping", nil)
+	w := httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", w.Code)
+	}
+	req = httptest.NewRequest("GET", "/api/ping", nil)
+	for i := 0; i < 10; i++ {
+		req.Header.Add(fmt.Sprintf("X-Extra-%d", i), "1")
+	}
+	w = httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	if w.Code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("got %d, want 431", w.Code)
+	}
+	if srv := s.newServer(":0"); srv.MaxHeaderBytes != 4096 {
+		t.Errorf("MaxHeaderBytes = %d, want 4096", srv.MaxHeaderBytes)
+	}
+}
+
+func TestBytesResponse(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("json", func(c *Context) (interface{}, error) {
+		return []byte("hi"), nil
+	})
+	s.HandleFunc("raw", func(c *Context) (interface{}, error) {
+		c.SetResourceType("application/octet-stream")
+		return []byte("hi"), nil
+	})
+	res, _ := s.ServeTest("GET", "/api/json", nil)
+	if string(res.Body) != `"aGk="`+"\n" {
+		t.Errorf("json body = %q, want base64 string", res.Body)
+	}
+	res, _ = s.ServeTest("GET", "/api/raw", nil)
+	if string(res.Body) != "hi" || res.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("raw body = %q %q, want raw bytes", res.Body, res.Header.Get("Content-Type"))
+	}
+}
+
+func TestIndex(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("users", func(c *Context) (interface{}, error) { return nil, nil })
+	res, _ := s.ServeTest("GET", "/api/", nil)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("bare prefix without index: got %d, want 404", res.Code)
+	}
+	s.IndexResources = true
+	res, _ = s.ServeTest("GET", "/api/", nil)
+	if res.Code != http.StatusOK || string(res.Body) != `{"resources":["users"]}`+"\n" {
+		t.Errorf("IndexResources: got %d %q", res.Code, res.Body)
+	}
+	s.HandleIndex(func(c *Context) (interface{}, error) {
+		return map[string]string{"welcome": "api"}, nil
+	})
+	res, _ = s.ServeTest("GET", "/api/", nil)
+	if res.Code != http.StatusOK || string(res.Body) != `{"welcome":"api"}`+"\n" {
+		t.Errorf("HandleIndex: got %d %q", res.Code, res.Body)
+	}
+}
+
+func TestExposeInternalErrors(t *testing.T) {
+	s := newTestServer()
+	logger := &captureLogger{}
+	s.Logger = logger
+	s.HandleFunc("db", func(c *Context) (interface{}, error) {
+		return nil, errors.New("pq: relation \"users\" does not exist at /srv/app/db.go")
+	})
+	res, _ := s.ServeTest("GET", "/api/db", nil)
+	if res.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d, want 500", res.Code)
+	}
+	if strings.Contains(string(res.Body), "pq:") || strings.Contains(string(res.Body), "/srv/app") {
+		t.Errorf("internal details leaked: %q", res.Body)
+	}
+	if !strings.Contains(strings.Join(logger.output(), "\n"), "pq: relation") {
+		t.Errorf("real error not logged: %q", logger.output())
+	}
+	s.ExposeInternalErrors = true
+	res, _ = s.ServeTest("GET", "/api/db", nil)
+	if !strings.Contains(string(res.Body), "pq: relation") {
+		t.Errorf("ExposeInternalErrors: body = %q", res.Body)
+	}
+}
+
+func TestSetRetryAfter(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	tests := []struct {
+		set  func(c *Context)
+		want string
+	}{
+		{func(c *Context) { c.SetRetryAfter(30 * time.Second) }, "30"},
+		{func(c *Context) { c.SetRetryAfter(1500 * time.Millisecond) }, "2"},
+		{func(c *Context) { c.SetRetryAfter(-time.Second) }, "0"},
+		{func(c *Context) { c.SetRetryAfterTime(at) }, "Fri, 02 Jan 2026 02:04:05 GMT"},
+	}
+	for i, tt := range tests {
+		s := newTestServer()
+		s.HandleFunc("busy", func(c *Context) (interface{}, error) {
+			tt.set(c)
+			return nil, nil
+		})
+		res, _ := s.ServeTest("GET", "/api/busy", nil)
+		if got := res.Header.Get("Retry-After"); got != tt.want {
+			t.Errorf("%d: Retry-After = %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestStreamArray(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("ingest", func(c *Context) (interface{}, error) {
+		n, sum := 0, 0
+		err := c.StreamArray(func(dec *json.Decoder) error {
+			var v struct{ N int }
+			if err := dec.Decode(&v); err != nil {
+				return err
+			}
+			n++
+			sum += v.N
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]int{"count": n, "sum": sum}, nil
+	})
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i := 0; i < 10000; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `{"n":%d}`, i)
+	}
+	buf.WriteByte(']')
+	res, _ := s.ServeTest("POST", "/api/ingest", &buf)
+	if string(res.Body) != `{"count":10000,"sum":49995000}`+"\n" {
+		t.Errorf("got %d %q", res.Code, res.Body)
+	}
+	res, _ = s.ServeTest("POST", "/api/ingest", strings.NewReader(`{"n":1}`))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("non-array: got %d, want 400", res.Code)
+	}
+}
+
+func TestContentSniffing(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	s := newTestServer()
+	s.HandleFunc("avatar", func(c *Context) (interface{}, error) {
+		c.SetResourceType(c.DetectContentType(png))
+		if c.FormValue("sniff", "") != "" {
+			c.AllowContentSniffing()
+		}
+		return png, nil
+	})
+	res, _ := s.ServeTest("GET", "/api/avatar", nil)
+	if got := res.Header.Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", got)
+	}
+	if res.Header.Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("nosniff missing by default")
+	}
+	res, _ = s.ServeTest("GET", "/api/avatar?sniff=1", nil)
+	if _, ok := res.Header["X-Content-Type-Options"]; ok {
+		t.Error("nosniff still set after AllowContentSniffing")
+	}
+}
+
+func TestParseJsonErrors(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("users", func(c *Context) (interface{}, error) {
+		var u struct {
+			Name string `json:"name"`
+			Age  int    `json:"age"`
+		}
+		if err := c.ParseJson(&u); err != nil && err != io.EOF {
+			return nil, err
+		}
+		return u, nil
+	})
+	tests := []struct {
+		body   string
+		code   int
+		reason string
+	}{
+		{`{"name":"ann","age":3}`, http.StatusOK, ""},
+		{`{"name":"ann",}`, http.StatusBadRequest, "Malformed JSON at offset 15"},
+		{`{"name":"ann","age":"three"}`, http.StatusBadRequest, "Invalid value for 'age'"},
+		{``, http.StatusOK, `"name":""`},
+	}
+	for _, tt := range tests {
+		res, _ := s.ServeTest("POST", "/api/users", strings.NewReader(tt.body))
+		if res.Code != tt.code || !strings.Contains(string(res.Body), tt.reason) {
+			t.Errorf("%q: got %d %q, want %d containing %q", tt.body, res.Code, res.Body, tt.code, tt.reason)
+		}
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("small", func(c *Context) (interface{}, error) {
+		return map[string]string{"a": "b"}, nil
+	})
+	s.HandleFunc("large", func(c *Context) (interface{}, error) {
+		return strings.Repeat("x", 64<<10), nil
+	})
+	res, _ := s.ServeTest("GET", "/api/small", nil)
+	if got := res.Header.Get("Content-Length"); got != fmt.Sprint(len(res.Body)) {
+		t.Errorf("small: Content-Length = %q, body %d bytes", got, len(res.Body))
+	}
+	res, _ = s.ServeTest("GET", "/api/large", nil)
+	if got := res.Header.Get("Content-Length"); got != fmt.Sprint(len(res.Body)) {
+		t.Errorf("large: Content-Length = %q, body %d bytes", got, len(res.Body))
+	}
+}
+
+func TestPrefixMismatch(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("users", func(c *Context) (interface{}, error) { return "ok", nil })
+	for _, path := range []string{"/", "/ap", "/v2/users", "/API/users"} {
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d, want 404", path, w.Code)
+		}
+	}
+}
+
+func TestCaseInsensitivePrefix(t *testing.T) {
+	for _, insensitive := range []bool{false, true} {
+		s := newTestServer()
+		s.CaseInsensitivePrefix = insensitive
+		s.Mux.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "static")
+		})
+		s.HandleFunc("users", func(c *Context) (interface{}, error) { return "ok", nil })
+		ts := httptest.NewServer(s.Mux)
+		tests := map[string]int{
+			"/api/users":  http.StatusOK,
+			"/API/users":  http.StatusNotFound,
+			"/Api/users":  http.StatusNotFound,
+			"/static/x":   http.StatusOK,
+			"/other/path": http.StatusNotFound,
+		}
+		if insensitive {
+			tests["/API/users"] = http.StatusOK
+			tests["/Api/users"] = http.StatusOK
+		}
+		for path, want := range tests {
+			res, err := http.Get(ts.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != want {
+				t.Errorf("insensitive=%v %s: got %d, want %d", insensitive, path, res.StatusCode, want)
+			}
+		}
+		ts.Close()
+	}
+}
+
+func TestCaseInsensitiveRoutes(t *testing.T) {
+	for _, insensitive := range []bool{false, true} {
+		s := newTestServer()
+		s.CaseInsensitiveRoutes = insensitive
+		s.HandleFunc("users", func(c *Context) (interface{}, error) {
+			return c.Path(1), nil
+		})
+		s.HandleMethod("GET", "orders", func(c *Context) (interface{}, error) {
+			return "orders", nil
+		})
+		for _, path := range []string{"/api/users/Ann", "/api/Users/Ann", "/api/USERS/Ann", "/api/Orders"} {
+			res, _ := s.ServeTest("GET", path, nil)
+			want := http.StatusOK
+			if !insensitive && path != "/api/users/Ann" {
+				want = http.StatusNotFound
+			}
+			if res.Code != want {
+				t.Errorf("insensitive=%v %s: got %d, want %d", insensitive, path, res.Code, want)
+			}
+			if res.Code == http.StatusOK && strings.HasSuffix(path, "Ann") && !strings.Contains(string(res.Body), "Ann") {
+				t.Errorf("insensitive=%v %s: param case not preserved: %q", insensitive, path, res.Body)
+			}
+		}
+	}
+
+	s := newTestServer()
+	s.CaseInsensitiveRoutes = true
+	s.HandleFunc("Items", func(c *Context) (interface{}, error) { return "Items", nil })
+	s.HandleFunc("items", func(c *Context) (interface{}, error) { return "items", nil })
+	if res, _ := s.ServeTest("GET", "/api/ITEMS", nil); res.Value != "Items" {
+		t.Errorf("first registration: got %v", res.Value)
+	}
+	s.Unregister("Items")
+	if res, _ := s.ServeTest("GET", "/api/ITEMS", nil); res.Value != "items" {
+		t.Errorf("after Unregister: got %d %v", res.Code, res.Value)
+	}
+	s.Unregister("items")
+	if res, _ := s.ServeTest("GET", "/api/ITEMS", nil); res.Code != http.StatusNotFound {
+		t.Errorf("after unregistering both: got %d", res.Code)
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("users", func(c *Context) (interface{}, error) {
+		return c.Path(1), nil
+	})
+	paths := []string{"/api//users", "/api/users//ann", "/api/users/ann/", "/api///users///ann//"}
+	if res, _ := s.ServeTest("POST", "/api//users", nil); res.Code != http.StatusNotFound {
+		t.Errorf("without CleanPath: got %d, want 404", res.Code)
+	}
+	s.CleanPath = true
+	for _, path := range paths {
+		res, _ := s.ServeTest("POST", path, nil)
+		if res.Code != http.StatusOK || (strings.Contains(path, "ann") && res.Value != "ann") {
+			t.Errorf("%s: got %d %q", path, res.Code, res.Body)
+		}
+	}
+	s.RedirectCleanPath = true
+	res, _ := s.ServeTest("GET", "/api//users/ann/?x=1", nil)
+	if res.Code != http.StatusMovedPermanently || res.Header.Get("Location") != "/api/users/ann?x=1" {
+		t.Errorf("redirect: got %d %q", res.Code, res.Header.Get("Location"))
+	}
+	res, _ = s.ServeTest("POST", "/api//users", nil)
+	if res.Code != http.StatusOK {
+		t.Errorf("POST is not redirected: got %d", res.Code)
+	}
+}
+
+func TestEncodeFailure(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("chan", func(c *Context) (interface{}, error) {
+		return struct {
+			Data string
+			Ch   chan int
+		}{strings.Repeat("x", 8<<10), make(chan int)}, nil
+	})
+	res, _ := s.ServeTest("GET", "/api/chan", nil)
+	if res.Code != http.StatusInternalServerError || string(res.Body) != "Failed to encode response\n" {
+		t.Errorf("got %d %q, want a clean 500", res.Code, res.Body)
+	}
+}
+
+func TestContextPoolReset(t *testing.T) {
+	c := acquireContext()
+	c.server, c.reqID, c.route, c.suffix, c.resType, c.resCode, c.status = newTestServer(), "id", "users", "users/1", "text/plain", 400, 201
+	c.Set("user", "ann")
+	c.SetHeader("X-Secret", "1")
+	c.WriteTrailer("X-Sum", "abc")
+	c.sniff, c.missing = true, 1
+	c.onRelease(func() {})
+	releaseContext(c)
+	if len(c.header) != 0 || len(c.values) != 0 {
+		t.Errorf("maps not cleared: %v %v", c.header, c.values)
+	}
+	header, values := c.header, c.values
+	if !reflect.DeepEqual(*c, Context{header: header, values: values}) {
+		t.Errorf("context not reset: %+v", *c)
+	}
+}
+
+func TestContextPoolIsolation(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("users", func(c *Context) (interface{}, error) {
+		leaked := map[string]interface{}{}
+		if v := c.Get("user"); v != nil {
+			leaked["value"] = v
+		}
+		if v := c.header.Get("X-User"); v != "" {
+			leaked["header"] = v
+		}
+		if c.status != 0 || c.trailer != nil || c.session != nil {
+			leaked["state"] = true
+		}
+		name := c.Path(1)
+		c.Set("user", name)
+		c.SetHeader("X-User", name)
+		c.WriteTrailer("X-Done", name)
+		return leaked, nil
+	})
+	for i := 0; i < 100; i++ {
+		res, _ := s.ServeTest("GET", fmt.Sprintf("/api/users/u%d", i), nil)
+		if string(res.Body) != "{}\n" {
+			t.Fatalf("request %d saw state from a previous request: %s", i, res.Body)
+		}
+		if got := res.Header.Get("X-User"); got != fmt.Sprintf("u%d", i) {
+			t.Fatalf("request %d: X-User = %q", i, got)
+		}
+	}
+}
+
+func TestContextPoolAllocs(t *testing.T) {
+	use := func(c *Context) {
+		c.Set("user", "ann")
+		c.SetHeader("X-User", "ann")
+	}
+	pooled := testing.AllocsPerRun(1000, func() {
+		c := acquireContext()
+		use(c)
+		releaseContext(c)
+	})
+	fresh := testing.AllocsPerRun(1000, func() {
+		use(new(Context))
+	})
+	if pooled >= fresh {
+		t.Errorf("pooled contexts allocate %.1f per request, fresh %.1f", pooled, fresh)
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	s := newTestServer()
+	s.HandleFunc("users", func(c *Context) (interface{}, error) {
+		c.Set("user", c.Path(1))
+		c.SetHeader("X-User", "ann")
+		return "ok", nil
+	})
+	req := httptest.NewRequest("GET", "/api/users/ann", nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.serveHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkContextPooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c := acquireContext()
+		c.Set("user", "ann")
+		c.SetHeader("X-User", "ann")
+		releaseContext(c)
+	}
+}
+
+func BenchmarkContextFresh(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c := new(Context)
+		c.Set("user", "ann")
+		c.SetHeader("X-User", "ann")
+	}
+}
+
+var benchBody interface{} = []byte("hello")
+
+func BenchmarkByteCheckReflect(b *testing.B) {
+	typeOfBytes := reflect.TypeOf([]byte(nil))
+	n := 0
+	for i := 0; i < b.N; i++ {
+		if v := reflect.ValueOf(benchBody); v.Kind() == reflect.Slice && v.Type() == typeOfBytes {
+			n += len(v.Bytes())
+		}
+	}
+}
+
+func BenchmarkByteCheckSwitch(b *testing.B) {
+	n := 0
+	for i := 0; i < b.N; i++ {
+		switch v := benchBody.(type) {
+		case []byte:
+			n += len(v)
+		case string:
+			n += len(v)
+		}
+	}
+}
+
+func BenchmarkServeBytes(b *testing.B) {
+	s := newTestServer()
+	s.HandleFunc("blob", func(c *Context) (interface{}, error) {
+		c.SetResourceType("application/octet-stream")
+		return benchBody, nil
+	})
+	req := httptest.NewRequest("GET", "/api/blob", nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.serveHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkManyRoutes(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		s := newTestServer()
+		s.CaseInsensitiveRoutes = true
+		s.Use(tagMiddleware("a"))
+		for i := 0; i < n; i++ {
+			s.HandleFunc(fmt.Sprintf("resource%d", i), func(c *Context) (interface{}, error) {
+				return nil, nil
+			})
+		}
+		lookups := []struct {
+			name string
+			path string
+			code int
+		}{
+			{"exact", fmt.Sprintf("/api/resource%d/x", n-1), http.StatusOK},
+			{"mixed-case", fmt.Sprintf("/api/Resource%d/x", n-1), http.StatusOK},
+			{"miss", "/api/unknown/x", http.StatusNotFound},
+		}
+		for _, l := range lookups {
+			b.Run(fmt.Sprintf("%d/%s", n, l.name), func(b *testing.B) {
+				req := httptest.NewRequest("GET", l.path, nil)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					w := httptest.NewRecorder()
+					s.serveHTTP(w, req)
+					if w.Code != l.code {
+						b.Fatalf("got %d", w.Code)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPathSegments(t *testing.T) {
+	tests := []struct {
+		suffix string
+		want   []string
+	}{
+		{"users", []string{"users", "", ""}},
+		{"users/", []string{"users", "", ""}},
+		{"users/ann", []string{"users", "ann", ""}},
+		{"users/ann/", []string{"users", "ann", ""}},
+		{"users//posts", []string{"users", "", "posts"}},
+		{"users/ann/posts/7", []string{"users", "ann", "posts"}},
+	}
+	for _, tt := range tests {
+		c := &Context{suffix: tt.suffix}
+		for i, want := range tt.want {
+			if got := c.Path(i); got != want {
+				t.Errorf("%q Path(%d) = %q, want %q", tt.suffix, i, got, want)
+			}
+		}
+		if got := c.Path(-1); got != "" {
+			t.Errorf("%q Path(-1) = %q", tt.suffix, got)
+		}
+	}
+	c := &Context{suffix: "users/ann/posts/7"}
+	if n := testing.AllocsPerRun(100, func() { c.Path(3) }); n != 0 {
+		t.Errorf("Path allocates %.0f times, want 0", n)
+	}
+}
+
+func BenchmarkPathLazy(b *testing.B) {
+	c := &Context{suffix: "users/ann/posts/7"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = c.Path(3)
+	}
+}
+
+func BenchmarkPathSplit(b *testing.B) {
+	suffix := "users/ann/posts/7"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = strings.Split(suffix, "/")[3]
+	}
+}
+
+func TestEncoderPoolReuse(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("bad", func(c *Context) (interface{}, error) {
+		return struct {
+			Data string
+			Ch   chan int
+		}{"partial", make(chan int)}, nil
+	})
+	s.HandleFunc("good", func(c *Context) (interface{}, error) {
+		return map[string]int{"n": 1}, nil
+	})
+	for i := 0; i < 50; i++ {
+		s.ServeTest("GET", "/api/bad", nil)
+		res, _ := s.ServeTest("GET", "/api/good", nil)
+		if string(res.Body) != `{"n":1}`+"\n" {
+			t.Fatalf("pooled encoder leaked a previous body: %q", res.Body)
+		}
+	}
+}
+
+func BenchmarkEncoderPooled(b *testing.B) {
+	v := map[string]interface{}{"id": 1, "name": "ann", "tags": []string{"a", "b"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		enc := acquireEncoder()
+		enc.Encode(v)
+		releaseEncoder(enc)
+	}
+}
+
+func BenchmarkEncoderFresh(b *testing.B) {
+	v := map[string]interface{}{"id": 1, "name": "ann", "tags": []string{"a", "b"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(v)
+	}
+}
+
+type writeCounter struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestSmallResponseSingleWrite(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("small", func(c *Context) (interface{}, error) {
+		return map[string]int{"id": 1}, nil
+	})
+	w := &writeCounter{ResponseRecorder: httptest.NewRecorder()}
+	s.serveHTTP(w, httptest.NewRequest("GET", "/api/small", nil))
+	if w.writes != 1 || w.Header().Get("Content-Length") != fmt.Sprint(w.Body.Len()) {
+		t.Errorf("got %d writes, Content-Length %q for %d bytes", w.writes, w.Header().Get("Content-Length"), w.Body.Len())
+	}
+}
+
+var smallPayload = map[string]interface{}{"id": 1, "name": "ann", "active": true}
+
+func BenchmarkSmallResponse(b *testing.B) {
+	s := newTestServer()
+	s.HandleFunc("small", func(c *Context) (interface{}, error) {
+		return smallPayload, nil
+	})
+	req := httptest.NewRequest("GET", "/api/small", nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.serveHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkSmallResponseFastPath(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		enc := acquireEncoder()
+		enc.Encode(smallPayload)
+		w.Header().Set("Content-Length", strconv.Itoa(enc.buf.Len()))
+		w.Write(enc.buf.Bytes())
+		releaseEncoder(enc)
+	}
+}
+
+func BenchmarkSmallResponseStreaming(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		json.NewEncoder(w).Encode(smallPayload)
+	}
+}
+
+func TestHandleOptions(t *testing.T) {
+	s := newTestServer()
+	s.HandleMethod("GET", "dav", func(c *Context) (interface{}, error) { return "get", nil })
+	s.HandleMethod("OPTIONS", "dav", func(c *Context) (interface{}, error) {
+		c.SetHeader("DAV", "1, 2")
+		return "options", nil
+	})
+	s.HandleMethod("GET", "plain", func(c *Context) (interface{}, error) { return "get", nil })
+	for _, handle := range []bool{false, true} {
+		s.HandleOptions = handle
+		res, _ := s.ServeTest("OPTIONS", "/api/dav", nil)
+		if handle && (res.Value != "options" || res.Header.Get("DAV") != "1, 2") {
+			t.Errorf("HandleOptions: got %d %q, want the handler's response", res.Code, res.Body)
+		}
+		if !handle && (len(res.Body) != 0 || res.Header.Get("Allow") != "GET, OPTIONS") {
+			t.Errorf("preflight: got %d %q Allow %q", res.Code, res.Body, res.Header.Get("Allow"))
+		}
+		res, _ = s.ServeTest("OPTIONS", "/api/plain", nil)
+		if res.Code != http.StatusOK || len(res.Body) != 0 || res.Header.Get("Allow") != "GET, OPTIONS" {
+			t.Errorf("handle=%v without OPTIONS handler: got %d %q Allow %q", handle, res.Code, res.Body, res.Header.Get("Allow"))
+		}
+	}
+}
+
+func TestHandleDefault(t *testing.T) {
+	s := newTestServer()
+	s.Use(tagMiddleware("mw"))
+	s.HandleFunc("users", func(c *Context) (interface{}, error) { return "users", nil })
+	s.HandleDefault(func(c *Context) (interface{}, error) {
+		trace, _ := c.Get("trace").(string)
+		c.SetResponseCode(http.StatusNotFound)
+		return map[string]string{"missing": c.Path(0), "trace": trace}, nil
+	})
+	res, _ := s.ServeTest("GET", "/api/users", nil)
+	if res.Value != "users" {
+		t.Errorf("registered resource: got %q", res.Body)
+	}
+	res, _ = s.ServeTest("GET", "/api/unknown/1", nil)
+	if res.Code != http.StatusNotFound || string(res.Body) != `{"missing":"unknown","trace":"mw\u003e"}`+"\n" {
+		t.Errorf("fallback: got %d %q", res.Code, res.Body)
+	}
+}
+
+func TestForwardableHeaders(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/proxy", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Trace", "abc")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Connection", "keep-alive, X-Hop")
+	req.Header.Set("X-Hop", "1")
+	for _, name := range hopHeaders {
+		if name != "Connection" {
+			req.Header.Set(name, "1")
+		}
+	}
+	c := &Context{request: req}
+	h := c.ForwardableHeaders("Authorization")
+	for _, name := range append([]string{"X-Hop", "Authorization"}, hopHeaders...) {
+		if _, ok := h[name]; ok {
+			t.Errorf("%s forwarded", name)
+		}
+	}
+	if h.Get("Accept") != "application/json" || h.Get("X-Trace") != "abc" {
+		t.Errorf("end-to-end headers dropped: %v", h)
+	}
+	all := c.Headers()
+	all.Set("X-Trace", "changed")
+	if req.Header.Get("X-Trace") != "abc" || req.Header.Get("Upgrade") == "" {
+		t.Error("Headers did not return a copy of the request headers")
+	}
+}
+
+func TestClientAddrPort(t *testing.T) {
+	tests := []struct {
+		remote string
+		port   int
+		addr   string
+		ok     bool
+	}{
+		{"192.0.2.1:1234", 1234, "192.0.2.1:1234", true},
+		{"[2001:db8::1]:443", 443, "[2001:db8::1]:443", true},
+		{"[fe80::1%eth0]:80", 80, "[fe80::1%eth0]:80", true},
+		{"192.0.2.1", 0, "", false},
+		{"[2001:db8::1]", 0, "", false},
+		{"192.0.2.1:http", 0, "", false},
+		{"192.0.2.1:70000", 0, "", false},
+		{"garbage", 0, "", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/x", nil)
+		req.RemoteAddr = tt.remote
+		c := &Context{request: req}
+		port, err := c.ClientPort()
+		if (err == nil) != tt.ok || port != tt.port {
+			t.Errorf("%s: ClientPort() = %d, %v", tt.remote, port, err)
+		}
+		ap, err := c.ClientAddrPort()
+		if (err == nil) != tt.ok || (tt.ok && ap.String() != tt.addr) {
+			t.Errorf("%s: ClientAddrPort() = %s, %v", tt.remote, ap, err)
+		}
+	}
+}
+
+func TestDisableHTMLEscape(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("link", func(c *Context) (interface{}, error) {
+		return map[string]string{"url": "/search?q=a&b=<c>"}, nil
+	})
+	res, _ := s.ServeTest("GET", "/api/link", nil)
+	if want := `{"url":"/search?q=a\u0026b=\u003cc\u003e"}` + "\n"; string(res.Body) != want {
+		t.Errorf("escaped: got %q, want %q", res.Body, want)
+	}
+	s.DisableHTMLEscape = true
+	res, _ = s.ServeTest("GET", "/api/link", nil)
+	if want := `{"url":"/search?q=a&b=<c>"}` + "\n"; string(res.Body) != want {
+		t.Errorf("unescaped: got %q, want %q", res.Body, want)
+	}
+}
+
+func TestHandleFuncAliases(t *testing.T) {
+	s := newTestServer()
+	calls := 0
+	s.HandleFuncAliases(func(c *Context) (interface{}, error) {
+		calls++
+		return c.Path(0), nil
+	}, "people", "users")
+	for _, name := range []string{"people", "users"} {
+		res, _ := s.ServeTest("GET", "/api/"+name+"/1", nil)
+		if res.Code != http.StatusOK || res.Value != name {
+			t.Errorf("%s: got %d %q", name, res.Code, res.Body)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+	if res, _ := s.ServeTest("GET", "/api/persons", nil); res.Code != http.StatusNotFound {
+		t.Errorf("unaliased name: got %d", res.Code)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func TestMaxDrainBytes(t *testing.T) {
+	const size = 1 << 20
+	s := newTestServer()
+	s.HandleFunc("ignore", func(c *Context) (interface{}, error) {
+		return "ignored", nil
+	})
+	for _, limit := range []int64{0, 1024, 2 * size} {
+		s.MaxDrainBytes = limit
+		body := &countingReader{r: strings.NewReader(strings.Repeat("x", size))}
+		req := httptest.NewRequest("POST", "/api/ignore", body)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, req)
+		closed := w.Header().Get("Connection") == "close"
+		if limit == 1024 {
+			if !closed || body.n > 64<<10 {
+				t.Errorf("limit %d: Connection %q after reading %d bytes", limit, w.Header().Get("Connection"), body.n)
+			}
+			continue
+		}
+		if closed || body.n != size {
+			t.Errorf("limit %d: Connection %q after reading %d bytes, want full drain", limit, w.Header().Get("Connection"), body.n)
+		}
+	}
+}
+
+type trackingBody struct {
+	r    io.Reader
+	read atomic.Bool
+}
+
+func (b *trackingBody) Read(p []byte) (int, error) {
+	b.read.Store(true)
+	return b.r.Read(p)
+}
+
+func TestExpectContinue(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("upload", func(c *Context) (interface{}, error) {
+		if c.request.Header.Get("Authorization") != "ok" {
+			c.SetErrorResponseCode(http.StatusUnauthorized)
+			return nil, Errorf(http.StatusUnauthorized, "Unauthorized")
+		}
+		data, err := io.ReadAll(c.request.Body)
+		if err != nil {
+			return nil, err
+		}
+		return len(data), nil
+	})
+	ts := httptest.NewServer(s.Mux)
+	defer ts.Close()
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+	for _, auth := range []string{"", "ok"} {
+		body := &trackingBody{r: strings.NewReader(strings.Repeat("x", 4096))}
+		req, _ := http.NewRequest("POST", ts.URL+"/api/upload", body)
+		req.ContentLength = 4096
+		req.Header.Set("Expect", "100-continue")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", auth)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if auth == "" && (res.StatusCode != http.StatusUnauthorized || body.read.Load()) {
+			t.Errorf("rejected: got %d, body sent %v", res.StatusCode, body.read.Load())
+		}
+		if auth == "ok" && (res.StatusCode != http.StatusOK || string(data) != "4096\n") {
+			t.Errorf("accepted: got %d %q", res.StatusCode, data)
+		}
+	}
+}
+
+func TestDeleteWithBody(t *testing.T) {
+	s := newTestServer()
+	s.HandleMethod("GET", "items", func(c *Context) (interface{}, error) { return "list", nil })
+	s.HandleMethod("DELETE", "items", func(c *Context) (interface{}, error) {
+		var filter struct {
+			IDs []int `json:"ids"`
+		}
+		if err := c.ParseJson(&filter); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"deleted": filter.IDs, "dry": c.FormBool("dry", false)}, nil
+	})
+	for _, ct := range []string{"application/json", "application/x-www-form-urlencoded", ""} {
+		req := httptest.NewRequest("DELETE", "/api/items?dry=true", strings.NewReader(`{"ids":[1,2,3]}`))
+		if ct != "" {
+			req.Header.Set("Content-Type", ct)
+		}
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, req)
+		if want := `{"deleted":[1,2,3],"dry":true}` + "\n"; w.Body.String() != want {
+			t.Errorf("Content-Type %q: got %d %q, want %q", ct, w.Code, w.Body.String(), want)
+		}
+	}
+}
+
+func TestElapsed(t *testing.T) {
+	s := newTestServer()
+	s.Use(func(next Handler) Handler {
+		return func(c *Context) (interface{}, error) {
+			res, err := next(c)
+			c.SetHeader("X-Response-Time", c.Elapsed().String())
+			return res, err
+		}
+	})
+	before := time.Now()
+	s.HandleFunc("slow", func(c *Context) (interface{}, error) {
+		first := c.Elapsed()
+		time.Sleep(5 * time.Millisecond)
+		if second := c.Elapsed(); second-first < 5*time.Millisecond {
+			t.Errorf("Elapsed went from %s to %s across a 5ms sleep", first, second)
+		}
+		if c.StartTime().Before(before) || c.StartTime().After(time.Now()) {
+			t.Errorf("StartTime %s outside the request", c.StartTime())
+		}
+		return nil, nil
+	})
+	res, _ := s.ServeTest("GET", "/api/slow", nil)
+	d, err := time.ParseDuration(res.Header.Get("X-Response-Time"))
+	if err != nil || d < 5*time.Millisecond {
+		t.Errorf("X-Response-Time = %q", res.Header.Get("X-Response-Time"))
+	}
+}
+
+func TestRetryableError(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("busy", func(c *Context) (interface{}, error) {
+		return nil, RetryableErrorf(http.StatusServiceUnavailable, 30*time.Second, "Try later")
+	})
+	s.HandleFunc("limited", func(c *Context) (interface{}, error) {
+		return nil, RetryableErrorf(http.StatusTooManyRequests, 0, "Slow down")
+	})
+	s.HandleFunc("bad", func(c *Context) (interface{}, error) {
+		return nil, Errorf(http.StatusBadRequest, "Bad input")
+	})
+	s.HandleFunc("down", func(c *Context) (interface{}, error) {
+		c.SetErrorResponseCode(http.StatusServiceUnavailable)
+		return nil, RetryableErrorf(http.StatusServiceUnavailable, time.Minute, "Down")
+	})
+	tests := []struct {
+		path       string
+		code       int
+		retryAfter string
+		body       string
+	}{
+		{"/api/busy", http.StatusOK, "30", `{"error":503,"reason":"Try later","retryable":true}`},
+		{"/api/limited", http.StatusOK, "", `{"error":429,"reason":"Slow down","retryable":true}`},
+		{"/api/bad", http.StatusOK, "", `{"error":400,"reason":"Bad input"}`},
+		{"/api/down", http.StatusServiceUnavailable, "60", `{"error":503,"reason":"Down","retryable":true}`},
+	}
+	for _, tt := range tests {
+		res, _ := s.ServeTest("GET", tt.path, nil)
+		if res.Code != tt.code || res.Header.Get("Retry-After") != tt.retryAfter || string(res.Body) != tt.body+"\n" {
+			t.Errorf("%s: got %d Retry-After %q %q", tt.path, res.Code, res.Header.Get("Retry-After"), res.Body)
+		}
+	}
+}
+
+func TestConcurrentRegistration(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("stable", func(c *Context) (interface{}, error) { return "ok", nil })
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var writers sync.WaitGroup
+	writers.Add(2)
+	go func() {
+		defer writers.Done()
+		for i := 0; i < 200; i++ {
+			name := fmt.Sprintf("r%d", i%10)
+			s.HandleFunc(name, func(c *Context) (interface{}, error) { return name, nil })
+			s.HandleMethod("POST", name, func(c *Context) (interface{}, error) { return nil, nil })
+			if i%20 == 0 {
+				s.Use(tagMiddleware("m"))
+			}
+			s.Unregister(name)
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for i := 0; i < 200; i++ {
+			s.RegisterCodec(fmt.Sprintf("text/x-%d", i%5), textCodec{})
+			s.HandleSPA(fstest.MapFS{"index.html": {Data: []byte("app")}}, "index.html")
+		}
+	}()
+	go func() {
+		writers.Wait()
+		close(stop)
+	}()
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				req := httptest.NewRequest("GET", fmt.Sprintf("/api/r%d", i%10), nil)
+				req.Header.Set("Accept", []string{"text/x-1", "text/html", "application/json", "*/*"}[(g+i)%4])
+				s.serveHTTP(httptest.NewRecorder(), req)
+				res, _ := s.ServeTest("GET", "/api/stable", nil)
+				if res.Code != http.StatusOK {
+					t.Errorf("stable route: got %d", res.Code)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestUnregister(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("plugin", func(c *Context) (interface{}, error) { return "v1", nil })
+	s.HandleMethod("GET", "other", func(c *Context) (interface{}, error) { return "other", nil })
+	if res, _ := s.ServeTest("GET", "/api/plugin", nil); res.Value != "v1" {
+		t.Fatalf("registered: got %d %q", res.Code, res.Body)
+	}
+	s.Unregister("plugin")
+	if res, _ := s.ServeTest("GET", "/api/plugin", nil); res.Code != http.StatusNotFound {
+		t.Errorf("after Unregister: got %d, want 404", res.Code)
+	}
+	if routes := s.Routes(); len(routes) != 1 || routes[0].Resource != "other" {
+		t.Errorf("Routes() = %+v", routes)
+	}
+	s.HandleFunc("plugin", func(c *Context) (interface{}, error) { return "v2", nil })
+	if res, _ := s.ServeTest("GET", "/api/plugin", nil); res.Value != "v2" {
+		t.Errorf("re-registered: got %d %q", res.Code, res.Body)
+	}
+	s.Unregister("other")
+	if res, _ := s.ServeTest("GET", "/api/other", nil); res.Code != http.StatusNotFound {
+		t.Errorf("method route after Unregister: got %d, want 404", res.Code)
+	}
+}
+
+func TestResponseTransformer(t *testing.T) {
+	s := newTestServer()
+	s.ResponseTransformer = func(c *Context, v interface{}) interface{} {
+		return map[string]interface{}{"data": v, "route": c.Path(0)}
+	}
+	s.HandleFunc("users", func(c *Context) (interface{}, error) { return []string{"ann"}, nil })
+	s.HandleFunc("created", func(c *Context) (interface{}, error) {
+		return Response{Status: http.StatusCreated, Body: map[string]int{"id": 1}}, nil
+	})
+	s.HandleFunc("fail", func(c *Context) (interface{}, error) { return nil, Errorf(http.StatusConflict, "Conflict") })
+	s.HandleFunc("raw", func(c *Context) (interface{}, error) {
+		c.SetResourceType("text/plain")
+		return "raw", nil
+	})
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/api/users", http.StatusOK, `{"data":["ann"],"route":"users"}` + "\n"},
+		{"/api/created", http.StatusCreated, `{"data":{"id":1},"route":"created"}` + "\n"},
+		{"/api/fail", http.StatusOK, `{"error":409,"reason":"Conflict"}` + "\n"},
+		{"/api/raw", http.StatusOK, "raw"},
+	}
+	for _, tt := range tests {
+		res, _ := s.ServeTest("GET", tt.path, nil)
+		if res.Code != tt.code || string(res.Body) != tt.body {
+			t.Errorf("%s: got %d %q, want %d %q", tt.path, res.Code, res.Body, tt.code, tt.body)
+		}
+	}
+}
+
+func TestNotFoundKinds(t *testing.T) {
+	users := func(c *Context) (interface{}, error) {
+		if c.Path(1) != "" {
+			return c.NotFoundPath()
+		}
+		return "users", nil
+	}
+	s := newTestServer()
+	s.HandleFunc("users", users)
+	if res, _ := s.ServeTest("GET", "/api/users/x", nil); res.Code != http.StatusNotFound || !strings.Contains(string(res.Body), "No such path 'x' on 'users'") {
+		t.Errorf("unknown path: got %d %q", res.Code, res.Body)
+	}
+	if res, _ := s.ServeTest("GET", "/api/nope", nil); res.Code != http.StatusNotFound {
+		t.Errorf("unknown resource: got %d", res.Code)
+	}
+
+	s = newTestServer()
+	s.NotFoundHandler = func(c *Context) (interface{}, error) {
+		switch c.NotFound() {
+		case UnknownResource:
+			return "no resource " + c.route, nil
+		case UnknownPath:
+			return "bad path on " + c.route, nil
+		}
+		return "unexpected", nil
+	}
+	s.HandleFunc("users", users)
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/api/users", http.StatusOK, "users"},
+		{"/api/users/x/y", http.StatusNotFound, "bad path on users"},
+		{"/api/nope", http.StatusNotFound, "no resource nope"},
+	}
+	for _, tt := range tests {
+		res, err := s.ServeTest("GET", tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Code != tt.code || res.Value != tt.body {
+			t.Errorf("%s: got %d %v, want %d %q", tt.path, res.Code, res.Value, tt.code, tt.body)
+		}
+	}
+}
+
+func TestOnError(t *testing.T) {
+	s := newTestServer()
+	var seen []error
+	s.OnError = func(c *Context, err error) {
+		seen = append(seen, err)
+	}
+	boom := errors.New("boom")
+	s.HandleFunc("ok", func(c *Context) (interface{}, error) { return "fine", nil })
+	s.HandleFunc("api", func(c *Context) (interface{}, error) { return nil, Errorf(http.StatusConflict, "Taken") })
+	s.HandleFunc("generic", func(c *Context) (interface{}, error) { return nil, boom })
+	s.HandleFunc("panic", func(c *Context) (interface{}, error) { panic("kaboom") })
+
+	if res, _ := s.ServeTest("GET", "/api/ok", nil); res.Code != http.StatusOK || len(seen) != 0 {
+		t.Errorf("success: got %d, hook saw %v", res.Code, seen)
+	}
+	seen = nil
+	if res, _ := s.ServeTest("GET", "/api/api", nil); res.Value.(map[string]interface{})["error"] != float64(http.StatusConflict) || len(seen) != 1 {
+		t.Errorf("Error: got %v, hook saw %v", res.Value, seen)
+	} else if e, ok := seen[0].(Error); !ok || e.Code != http.StatusConflict {
+		t.Errorf("Error: hook saw %#v", seen[0])
+	}
+	seen = nil
+	if res, _ := s.ServeTest("GET", "/api/generic", nil); res.Code != http.StatusInternalServerError || len(seen) != 1 || seen[0] != boom {
+		t.Errorf("generic: got %d, hook saw %v", res.Code, seen)
+	}
+	seen = nil
+	res, _ := s.ServeTest("GET", "/api/panic", nil)
+	var pe *PanicError
+	if res.Code != http.StatusInternalServerError || len(seen) != 1 || !errors.As(seen[0], &pe) || pe.Value != "kaboom" {
+		t.Errorf("panic: got %d, hook saw %v", res.Code, seen)
+	}
+}
+
+func TestMultipleSetCookie(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("cookies", func(c *Context) (interface{}, error) {
+		c.SetCookie(&http.Cookie{Name: "a", Value: "1"})
+		c.SetCookie(&http.Cookie{Name: "b", Value: "2"})
+		c.SetHeader("Set-Cookie", "c=3")
+		c.SetHeader("Link", "</a.css>; rel=preload")
+		c.SetHeader("Link", "</b.js>; rel=preload")
+		c.SetHeader("X-Single", "first")
+		c.SetHeader("X-Single", "second")
+		return "ok", nil
+	})
+	res, err := s.ServeTest("GET", "/api/cookies", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Header.Values("Set-Cookie"); strings.Join(got, "|") != "a=1|b=2|c=3" {
+		t.Errorf("Set-Cookie = %q", got)
+	}
+	if got := res.Header.Values("Link"); len(got) != 2 {
+		t.Errorf("Link = %q", got)
+	}
+	if got := res.Header.Values("X-Single"); len(got) != 1 || got[0] != "second" {
+		t.Errorf("X-Single = %q", got)
+	}
+}
+
+func TestGoCancellation(t *testing.T) {
+	s := newTestServer()
+	detached := make(chan error, 1)
+	s.HandleFunc("detached", func(c *Context) (interface{}, error) {
+		c.Go(func(ctx context.Context) {
+			<-ctx.Done()
+			detached <- ctx.Err()
+		})
+		return "ok", nil
+	})
+	started, waited := make(chan struct{}), make(chan error, 1)
+	s.HandleFunc("waited", func(c *Context) (interface{}, error) {
+		c.Go(func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+			waited <- ctx.Err()
+		})
+		c.Wait()
+		return "ok", nil
+	})
+	ts := httptest.NewServer(s.Mux)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/api/detached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+	select {
+	case err := <-detached:
+		if err != context.Canceled {
+			t.Errorf("detached goroutine saw %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("detached goroutine not canceled after the request completed")
+	}
+
+	rctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(rctx, "GET", ts.URL+"/api/waited", nil)
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := http.DefaultClient.Do(req); err == nil {
+		t.Error("canceled request succeeded")
+	}
+	select {
+	case err := <-waited:
+		if err != context.Canceled {
+			t.Errorf("waited goroutine saw %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("goroutine not canceled when the client went away")
+	}
+}
+
+func TestAwaitGoroutines(t *testing.T) {
+	s := newTestServer()
+	s.AwaitGoroutines = true
+	var done atomic.Bool
+	s.HandleFunc("work", func(c *Context) (interface{}, error) {
+		c.Go(func(ctx context.Context) {
+			time.Sleep(10 * time.Millisecond)
+			done.Store(ctx.Err() == nil)
+		})
+		return "ok", nil
+	})
+	s.serveHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/work", nil))
+	if !done.Load() {
+		t.Error("serveHTTP returned before the goroutine finished")
+	}
+}
+
+func TestEarlyHints(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("page", func(c *Context) (interface{}, error) {
+		if err := c.EarlyHints("/app.css", "</app.js>; rel=preload; as=script"); err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	})
+	ts := httptest.NewServer(s.Mux)
+	defer ts.Close()
+	var hints []textproto.MIMEHeader
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				hints = append(hints, header)
+			}
+			return nil
+		},
+	}
+	req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", ts.URL+"/api/page", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if len(hints) != 1 {
+		t.Fatalf("got %d early hints responses, want 1", len(hints))
+	}
+	want := []string{"</app.css>; rel=preload", "</app.js>; rel=preload; as=script"}
+	if got := hints[0].Values("Link"); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("early hints Link = %q, want %q", got, want)
+	}
+	if res.StatusCode != http.StatusOK || string(body) != `"ok"`+"\n" {
+		t.Errorf("final response %d %q", res.StatusCode, body)
+	}
+	if got := res.Header.Values("Link"); len(got) != 0 {
+		t.Errorf("hints leaked into the final response: %q", got)
+	}
+}
+
+func TestDebugErrors(t *testing.T) {
+	for _, debug := range []bool{false, true} {
+		s := newTestServer()
+		s.DebugErrors = debug
+		s.HandleFunc("panic", func(c *Context) (interface{}, error) { panic("kaboom") })
+		s.HandleFunc("wrapped", func(c *Context) (interface{}, error) {
+			return nil, fmt.Errorf("Loading user: %w", errors.New("connection refused"))
+		})
+
+		res, err := s.ServeTest("GET", "/api/panic", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Code != http.StatusInternalServerError {
+			t.Errorf("debug=%v: panic status %d", debug, res.Code)
+		}
+		hasStack := strings.Contains(string(res.Body), "goroutine") || strings.Contains(string(res.Body), "TestDebugErrors")
+		if hasStack != debug {
+			t.Errorf("debug=%v: stack in panic body = %v: %s", debug, hasStack, res.Body)
+		}
+		if debug {
+			info, _ := res.Value.(map[string]interface{})["debug"].(map[string]interface{})
+			if stack, _ := info["stack"].(string); !strings.Contains(stack, "runtime/debug.Stack") {
+				t.Errorf("panic stack = %q", stack)
+			}
+		}
+
+		res, err = s.ServeTest("GET", "/api/wrapped", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Code != http.StatusInternalServerError {
+			t.Errorf("debug=%v: wrapped status %d", debug, res.Code)
+		}
+		if leaked := strings.Contains(string(res.Body), "connection refused"); leaked != debug {
+			t.Errorf("debug=%v: error chain in body = %v: %s", debug, leaked, res.Body)
+		}
+		if strings.Contains(string(res.Body), "stack") {
+			t.Errorf("debug=%v: stack for a plain error: %s", debug, res.Body)
+		}
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	tests := []struct {
+		err  Error
+		code int
+		typ  string
+	}{
+		{BadRequestf("Bad"), http.StatusBadRequest, "bad_request"},
+		{ValidationErrorf("Invalid"), http.StatusUnprocessableEntity, "validation_error"},
+		{Unauthorizedf("Who"), http.StatusUnauthorized, "unauthorized"},
+		{Forbiddenf("No"), http.StatusForbidden, "forbidden"},
+		{NotFoundf("Gone"), http.StatusNotFound, "not_found"},
+		{Conflictf("Taken"), http.StatusConflict, "conflict"},
+		{TypedErrorf(http.StatusTeapot, "teapot", "Short"), http.StatusTeapot, "teapot"},
+		{Errorf(http.StatusBadRequest, "Untyped"), http.StatusBadRequest, ""},
+	}
+	for _, renamed := range []bool{false, true} {
+		s := newTestServer()
+		if renamed {
+			s.ErrorFieldNames.Code, s.ErrorFieldNames.Message = "code", "message"
+		}
+		var current Error
+		s.HandleFunc("fail", func(c *Context) (interface{}, error) { return nil, current })
+		for _, tt := range tests {
+			if tt.err.Code != tt.code {
+				t.Errorf("%q: code %d, want %d", tt.err.Reason, tt.err.Code, tt.code)
+			}
+			current = tt.err
+			res, err := s.ServeTest("GET", "/api/fail", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			typ, ok := res.Value.(map[string]interface{})["type"]
+			if tt.typ == "" && ok {
+				t.Errorf("renamed=%v %q: unexpected type %v", renamed, tt.err.Reason, typ)
+			}
+			if tt.typ != "" && typ != tt.typ {
+				t.Errorf("renamed=%v %q: type %v, want %q", renamed, tt.err.Reason, typ, tt.typ)
+			}
+		}
+	}
+}
+
+func TestJSONBodySkipsFormParsing(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("items", func(c *Context) (interface{}, error) {
+		var item map[string]string
+		if err := c.ParseJson(&item); err != nil {
+			return nil, err
+		}
+		return item["name"], nil
+	})
+	post := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		s.serveHTTP(w, req)
+		return w
+	}
+	body := `{"name":"a%zz;b=100%"}`
+	w := post("/api/items", "application/json", body)
+	if w.Code != http.StatusOK || w.Body.String() != `"a%zz;b=100%"`+"\n" {
+		t.Errorf("JSON body: got %d %q", w.Code, w.Body.String())
+	}
+	w = post("/api/items?x=%zz", "application/json; charset=utf-8", body)
+	if w.Code != http.StatusOK {
+		t.Errorf("JSON body with malformed query: got %d %q", w.Code, w.Body.String())
+	}
+	w = post("/api/items", "application/x-www-form-urlencoded", "a=%zz")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("malformed form body: got %d, want 400", w.Code)
+	}
+}
+
+func TestNotFoundHandlerConcurrentUse(t *testing.T) {
+	s := newTestServer()
+	s.NotFoundHandler = func(c *Context) (interface{}, error) { return c.Get("trace"), nil }
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.Use(tagMiddleware("x"))
+		}()
+		go func() {
+			defer wg.Done()
+			s.ServeTest("GET", "/api/nope", nil)
+		}()
+	}
+	wg.Wait()
+	res, _ := s.ServeTest("GET", "/api/nope", nil)
+	if res.Code != http.StatusNotFound || res.Value != "x>x>x>x>" {
+		t.Errorf("got %d %v, want every middleware in the chain", res.Code, res.Value)
+	}
+}
+
+func TestParseErrorsKeepCause(t *testing.T) {
+	s := newTestServer()
+	var parseErr error
+	s.HandleFunc("users", func(c *Context) (interface{}, error) {
+		var u map[string]interface{}
+		parseErr = c.ParseJson(&u)
+		if _, err := c.ParseFormInt("page"); err == nil {
+			t.Error("missing page parsed")
+		}
+		return nil, Errorf(http.StatusConflict, "Taken")
+	})
+	res, _ := s.ServeTest("POST", "/api/users", strings.NewReader(`{"name":`+"\x01"+`}`))
+	var syntaxErr *json.SyntaxError
+	if !errors.As(parseErr, &syntaxErr) {
+		t.Errorf("ParseJson error %#v does not unwrap to *json.SyntaxError", parseErr)
+	}
+	if e, ok := parseErr.(Error); !ok || e.Code != http.StatusBadRequest {
+		t.Errorf("ParseJson error %#v, want a 400 Error", parseErr)
+	}
+	if res.Code != http.StatusOK || !strings.Contains(string(res.Body), "Taken") {
+		t.Errorf("ignored parse errors changed the response: %d %q", res.Code, res.Body)
+	}
+}
+
+func TestCaseInsensitivePrefixChangedAfterMount(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("users", func(c *Context) (interface{}, error) { return "ok", nil })
+	s.CaseInsensitivePrefix = true
+	defer func() {
+		if p := recover(); p == nil || !strings.Contains(fmt.Sprint(p), "CaseInsensitivePrefix") {
+			t.Errorf("recovered %v, want a CaseInsensitivePrefix panic", p)
+		}
+	}()
+	s.HandleFunc("orders", func(c *Context) (interface{}, error) { return "ok", nil })
+}
@@ -0,0 +1,39 @@
+package iorest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+)
+
+type TestResponse struct {
+	Code    int
+	Header  http.Header
+	Trailer http.Header
+	Body    []byte
+	Value   interface{}
+}
+
+func (s *Server) ServeTest(method, path string, body io.Reader) (*TestResponse, error) {
+	req, err := http.NewRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.RequestURI, req.RemoteAddr = path, "192.0.2.1:1234"
+	if req.Host == "" {
+		req.Host = "example.com"
+	}
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	w := httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	res := &TestResponse{Code: w.Code, Header: w.Header(), Trailer: w.Result().Trailer, Body: w.Body.Bytes()}
+	if isJSONType(res.Header.Get("Content-Type")) {
+		if err := json.Unmarshal(res.Body, &res.Value); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
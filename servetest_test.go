@@ -0,0 +1,61 @@
+package iorest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServeTest(t *testing.T) {
+	s := newTestServer()
+	s.Use(func(next Handler) Handler {
+		return func(c *Context) (interface{}, error) {
+			c.SetHeader("X-Middleware", "ran")
+			return next(c)
+		}
+	})
+	s.HandleFunc("echo", func(c *Context) (interface{}, error) {
+		var in map[string]string
+		if err := c.ParseJson(&in); err != nil {
+			return nil, err
+		}
+		c.SetResponseCode(http.StatusCreated)
+		return map[string]string{"name": in["name"], "id": c.Path(1)}, nil
+	})
+	res, err := s.ServeTest("POST", "/api/echo/7", strings.NewReader(`{"name":"bob"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != http.StatusCreated {
+		t.Errorf("Got status %d, want 201", res.Code)
+	}
+	if res.Header.Get("X-Middleware") != "ran" {
+		t.Error("Middleware did not run")
+	}
+	value, ok := res.Value.(map[string]interface{})
+	if !ok || value["name"] != "bob" || value["id"] != "7" {
+		t.Errorf("Decoded body %#v", res.Value)
+	}
+	res, err = s.ServeTest("GET", "/api/missing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != http.StatusNotFound || res.Value != nil {
+		t.Errorf("Unknown resource gave %d %v", res.Code, res.Value)
+	}
+}
+
+func TestServeTestMalformedRequest(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("echo", func(c *Context) (interface{}, error) { return "ok", nil })
+	for _, tt := range []struct{ method, path string }{
+		{"BAD METHOD", "/api/echo"},
+		{"GET", "/api/%zz"},
+		{"GET", "http://[::1/api/echo"},
+	} {
+		res, err := s.ServeTest(tt.method, tt.path, nil)
+		if err == nil || res != nil {
+			t.Errorf("%s %s: got %v, %v; want an error", tt.method, tt.path, res, err)
+		}
+	}
+}
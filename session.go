@@ -0,0 +1,281 @@
+package iorest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sessionCookie = "session"
+
+var (
+	errBadSession     = errors.New("Invalid session cookie")
+	errExpiredSession = errors.New("Expired session cookie")
+)
+
+type SessionStore interface {
+	Load(cookie string) (*SessionData, error)
+	Save(session *SessionData) (string, error)
+	Delete(id string) error
+}
+
+type SessionData struct {
+	ID        string
+	Values    map[string]interface{}
+	changed   bool
+	destroyed bool
+	previous  string
+}
+
+func (s *SessionData) Get(key string) interface{} {
+	return s.Values[key]
+}
+
+func (s *SessionData) Set(key string, value interface{}) {
+	if s.Values == nil {
+		s.Values = make(map[string]interface{})
+	}
+	s.Values[key] = value
+	s.changed = true
+}
+
+func (s *SessionData) Delete(key string) {
+	delete(s.Values, key)
+	s.changed = true
+}
+
+func (s *SessionData) Rotate() {
+	if s.previous == "" {
+		s.previous = s.ID
+	}
+	s.ID = ""
+	s.changed = true
+}
+
+func (s *SessionData) Destroy() {
+	s.destroyed = true
+}
+
+func (c *Context) Session() *SessionData {
+	return c.session
+}
+
+func Session(store SessionStore) Middleware {
+	return func(next Handler) Handler {
+		return func(c *Context) (interface{}, error) {
+			session, err := store.Load(c.Cookie(sessionCookie))
+			if err != nil || session == nil {
+				if err != nil {
+					c.Debugf("Discarding session: %s", err.Error())
+				}
+				session = &SessionData{}
+			}
+			c.session = session
+			res, err := next(c)
+			if session.previous != "" {
+				deleteSession(c, store, session.previous)
+			}
+			if session.destroyed && session.ID != "" {
+				deleteSession(c, store, session.ID)
+			}
+			cookie := &http.Cookie{Name: sessionCookie, Path: "/", HttpOnly: true, Secure: c.IsSecure(), SameSite: http.SameSiteLaxMode}
+			switch {
+			case session.destroyed:
+				cookie.MaxAge = -1
+				c.SetCookie(cookie)
+			case session.changed:
+				value, err := store.Save(session)
+				if err != nil {
+					c.Errorf("Failed to save session: %s", err.Error())
+					break
+				}
+				cookie.Value = value
+				c.SetCookie(cookie)
+			}
+			return res, err
+		}
+	}
+}
+
+func deleteSession(c *Context, store SessionStore, id string) {
+	if err := store.Delete(id); err != nil {
+		c.Warningf("Failed to delete session: %s", err.Error())
+	}
+}
+
+type signer []byte
+
+func (s signer) sign(value string) string {
+	mac := hmac.New(sha256.New, s)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s signer) verify(cookie string) (string, error) {
+	i := strings.LastIndexByte(cookie, '.')
+	if i < 0 {
+		return "", errBadSession
+	}
+	if !hmac.Equal([]byte(s.sign(cookie[:i])), []byte(cookie)) {
+		return "", errBadSession
+	}
+	return cookie[:i], nil
+}
+
+const defaultSessionTTL = 24 * time.Hour
+
+type MemoryStore struct {
+	TTL       time.Duration
+	signer    signer
+	mu        sync.Mutex
+	sessions  map[string]memorySession
+	nextSweep time.Time
+}
+
+type memorySession struct {
+	values  map[string]interface{}
+	expires time.Time
+}
+
+func NewMemoryStore(secret []byte) *MemoryStore {
+	return &MemoryStore{TTL: defaultSessionTTL, signer: secret, sessions: make(map[string]memorySession)}
+}
+
+func (m *MemoryStore) Load(cookie string) (*SessionData, error) {
+	if cookie == "" {
+		return nil, nil
+	}
+	id, err := m.signer.verify(cookie)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	if !time.Now().Before(session.expires) {
+		delete(m.sessions, id)
+		return nil, nil
+	}
+	return &SessionData{ID: id, Values: copyValues(session.values)}, nil
+}
+
+func (m *MemoryStore) Save(session *SessionData) (string, error) {
+	if session.ID == "" {
+		session.ID = randomHex(16)
+	}
+	ttl := m.ttl()
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !now.Before(m.nextSweep) {
+		m.sweep(now)
+		m.nextSweep = now.Add(ttl)
+	}
+	m.sessions[session.ID] = memorySession{values: copyValues(session.Values), expires: now.Add(ttl)}
+	return m.signer.sign(session.ID), nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemoryStore) ttl() time.Duration {
+	if m.TTL <= 0 {
+		return defaultSessionTTL
+	}
+	return m.TTL
+}
+
+func (m *MemoryStore) sweep(now time.Time) {
+	for id, session := range m.sessions {
+		if !now.Before(session.expires) {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	dup := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		dup[k] = v
+	}
+	return dup
+}
+
+const maxCookieBytes = 4096
+
+// CookieStore keeps sessions in the signed cookie itself. It is stateless,
+// so Delete, Destroy and Rotate cannot revoke a cookie that was already
+// issued: a copy stays valid until the expiry signed into it, TTL after
+// it was last saved.
+type CookieStore struct {
+	TTL    time.Duration
+	signer signer
+}
+
+func NewCookieStore(secret []byte) *CookieStore {
+	return &CookieStore{TTL: defaultSessionTTL, signer: secret}
+}
+
+type cookieSession struct {
+	ID      string                 `json:"id"`
+	Expires int64                  `json:"exp"`
+	Values  map[string]interface{} `json:"v,omitempty"`
+}
+
+func (s *CookieStore) Load(cookie string) (*SessionData, error) {
+	if cookie == "" {
+		return nil, nil
+	}
+	payload, err := s.signer.verify(cookie)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errBadSession
+	}
+	var cs cookieSession
+	if err := json.Unmarshal(data, &cs); err != nil {
+		return nil, errBadSession
+	}
+	if time.Now().Unix() >= cs.Expires {
+		return nil, errExpiredSession
+	}
+	return &SessionData{ID: cs.ID, Values: cs.Values}, nil
+}
+
+func (s *CookieStore) Save(session *SessionData) (string, error) {
+	if session.ID == "" {
+		session.ID = randomHex(16)
+	}
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	data, err := json.Marshal(cookieSession{ID: session.ID, Expires: time.Now().Add(ttl).Unix(), Values: session.Values})
+	if err != nil {
+		return "", err
+	}
+	value := s.signer.sign(base64.RawURLEncoding.EncodeToString(data))
+	if len(value) > maxCookieBytes {
+		return "", errors.New("Session exceeds cookie size limit")
+	}
+	return value, nil
+}
+
+func (s *CookieStore) Delete(id string) error {
+	return nil
+}
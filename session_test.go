@@ -0,0 +1,144 @@
+package iorest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func sessionServer(store SessionStore) *Server {
+	s := newTestServer()
+	mw := Session(store)
+	s.HandleFuncWith("login", func(c *Context) (interface{}, error) {
+		c.Session().Set("user", "ann")
+		return nil, nil
+	}, mw)
+	s.HandleFuncWith("whoami", func(c *Context) (interface{}, error) {
+		return c.Session().Get("user"), nil
+	}, mw)
+	s.HandleFuncWith("rotate", func(c *Context) (interface{}, error) {
+		c.Session().Rotate()
+		return nil, nil
+	}, mw)
+	s.HandleFuncWith("logout", func(c *Context) (interface{}, error) {
+		c.Session().Destroy()
+		return nil, nil
+	}, mw)
+	return s
+}
+
+func sessionRequest(s *Server, path, cookie string) (string, *http.Cookie) {
+	req := httptest.NewRequest("GET", "/api/"+path, nil)
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: cookie})
+	}
+	w := httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			return w.Body.String(), c
+		}
+	}
+	return w.Body.String(), nil
+}
+
+func TestSession(t *testing.T) {
+	stores := map[string]SessionStore{
+		"memory": NewMemoryStore([]byte("secret")),
+		"cookie": NewCookieStore([]byte("secret")),
+	}
+	for name, store := range stores {
+		s := sessionServer(store)
+		if body, cookie := sessionRequest(s, "whoami", ""); body != "{}\n" || cookie != nil {
+			t.Errorf("%s: unchanged session sent %v, body %q", name, cookie, body)
+		}
+		_, login := sessionRequest(s, "login", "")
+		if login == nil || !login.HttpOnly || login.Path != "/" {
+			t.Fatalf("%s: bad login cookie %v", name, login)
+		}
+		if body, _ := sessionRequest(s, "whoami", login.Value); body != `"ann"`+"\n" {
+			t.Errorf("%s: read back %q", name, body)
+		}
+		if body, _ := sessionRequest(s, "whoami", login.Value+"x"); body != "{}\n" {
+			t.Errorf("%s: tampered cookie accepted: %q", name, body)
+		}
+		_, rotated := sessionRequest(s, "rotate", login.Value)
+		if rotated == nil || rotated.Value == login.Value {
+			t.Fatalf("%s: rotate kept the cookie %v", name, rotated)
+		}
+		if body, _ := sessionRequest(s, "whoami", rotated.Value); body != `"ann"`+"\n" {
+			t.Errorf("%s: rotated session lost values: %q", name, body)
+		}
+		_, logout := sessionRequest(s, "logout", rotated.Value)
+		if logout == nil || logout.MaxAge != -1 {
+			t.Errorf("%s: logout did not clear the cookie: %v", name, logout)
+		}
+		if name != "memory" {
+			continue
+		}
+		if body, _ := sessionRequest(s, "whoami", login.Value); body != "{}\n" {
+			t.Errorf("rotation left the old session valid: %q", body)
+		}
+		if body, _ := sessionRequest(s, "whoami", rotated.Value); body != "{}\n" {
+			t.Errorf("logout left the session valid: %q", body)
+		}
+	}
+}
+
+func TestMemoryStoreTTL(t *testing.T) {
+	store := NewMemoryStore([]byte("secret"))
+	store.TTL = 20 * time.Millisecond
+	s := sessionServer(store)
+	_, first := sessionRequest(s, "login", "")
+	if body, _ := sessionRequest(s, "whoami", first.Value); body != `"ann"`+"\n" {
+		t.Fatalf("read back %q", body)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if body, _ := sessionRequest(s, "whoami", first.Value); body != "{}\n" {
+		t.Errorf("expired session accepted: %q", body)
+	}
+	_, abandoned := sessionRequest(s, "login", "")
+	time.Sleep(30 * time.Millisecond)
+	sessionRequest(s, "login", "")
+	store.mu.Lock()
+	n := len(store.sessions)
+	store.mu.Unlock()
+	if n != 1 {
+		t.Errorf("%d sessions kept after sweep, want 1", n)
+	}
+	if body, _ := sessionRequest(s, "whoami", abandoned.Value); body != "{}\n" {
+		t.Errorf("swept session accepted: %q", body)
+	}
+}
+
+func TestCookieStoreExpiry(t *testing.T) {
+	store := NewCookieStore([]byte("secret"))
+	s := sessionServer(store)
+	_, login := sessionRequest(s, "login", "")
+	sealed := func(cs cookieSession) string {
+		data, _ := json.Marshal(cs)
+		return store.signer.sign(base64.RawURLEncoding.EncodeToString(data))
+	}
+	values := map[string]interface{}{"user": "ann"}
+	tests := []struct {
+		name   string
+		cookie string
+		body   string
+	}{
+		{"fresh", login.Value, `"ann"` + "\n"},
+		{"expired", sealed(cookieSession{ID: "a", Expires: time.Now().Add(-time.Second).Unix(), Values: values}), "{}\n"},
+		{"no expiry", sealed(cookieSession{ID: "a", Values: values}), "{}\n"},
+		{"valid", sealed(cookieSession{ID: "a", Expires: time.Now().Add(time.Hour).Unix(), Values: values}), `"ann"` + "\n"},
+	}
+	for _, tt := range tests {
+		if body, _ := sessionRequest(s, "whoami", tt.cookie); body != tt.body {
+			t.Errorf("%s: got %q, want %q", tt.name, body, tt.body)
+		}
+	}
+	if session, err := store.Load(tests[1].cookie); session != nil || err != errExpiredSession {
+		t.Errorf("Load(expired) = %v, %v", session, err)
+	}
+}
@@ -0,0 +1,36 @@
+package iorest
+
+import (
+	"sync/atomic"
+)
+
+type ServerStats struct {
+	InFlight    int64
+	Total       int64
+	TotalErrors int64
+}
+
+type serverCounters struct {
+	inFlight    atomic.Int64
+	total       atomic.Int64
+	totalErrors atomic.Int64
+}
+
+func (s *Server) Stats() ServerStats {
+	return ServerStats{
+		InFlight:    s.counters.inFlight.Load(),
+		Total:       s.counters.total.Load(),
+		TotalErrors: s.counters.totalErrors.Load(),
+	}
+}
+
+func (s *Server) track(rw *responseWriter) func(failed *bool) {
+	s.counters.inFlight.Add(1)
+	s.counters.total.Add(1)
+	return func(failed *bool) {
+		s.counters.inFlight.Add(-1)
+		if *failed || rw.status >= 400 {
+			s.counters.totalErrors.Add(1)
+		}
+	}
+}
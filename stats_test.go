@@ -0,0 +1,43 @@
+package iorest
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	s := newTestServer()
+	release := make(chan struct{})
+	s.HandleFunc("slow", func(c *Context) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	s.HandleFunc("fail", func(c *Context) (interface{}, error) {
+		c.SetErrorResponseCode(http.StatusBadRequest)
+		return nil, Errorf(http.StatusBadRequest, "Bad request")
+	})
+	const n = 5
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.ServeTest("GET", "/api/slow", nil)
+		}()
+	}
+	deadline := time.Now().Add(time.Second)
+	for s.Stats().InFlight != n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := s.Stats(); got.InFlight != n || got.Total != n {
+		t.Errorf("while blocked: %+v, want %d in flight", got, n)
+	}
+	close(release)
+	wg.Wait()
+	s.ServeTest("GET", "/api/fail", nil)
+	if got, want := s.Stats(), (ServerStats{InFlight: 0, Total: n + 1, TotalErrors: 1}); got != want {
+		t.Errorf("after: %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+package iorest
+
+import (
+	"net/http"
+	"reflect"
+)
+
+func streamChannel(res interface{}) (<-chan interface{}, bool) {
+	switch ch := res.(type) {
+	case <-chan interface{}:
+		return ch, true
+	case chan interface{}:
+		return ch, true
+	}
+	return nil, false
+}
+
+func (s *Server) stream(c *Context, w http.ResponseWriter, ch <-chan interface{}, status int) {
+	sse := c.resType == "text/event-stream"
+	if q, spec := quality(parseAccept(c.request.Header.Get("Accept")), "text/event-stream"); q > 0 && spec >= 2 {
+		sse = true
+	}
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+	enc := acquireEncoder()
+	defer releaseEncoder(enc)
+	enc.SetEscapeHTML(!s.DisableHTMLEscape)
+	for {
+		select {
+		case <-c.ctx.Done():
+			c.Debugf("Stream cancelled: %s", c.ctx.Err().Error())
+			return
+		case v, ok := <-ch:
+			if !ok {
+				return
+			}
+			enc.buf.Reset()
+			if sse {
+				enc.buf.WriteString("data: ")
+			}
+			if s.FieldNameMapper != nil {
+				v = mapFieldNames(reflect.ValueOf(v), s.FieldNameMapper)
+			}
+			if err := enc.Encode(v); err != nil {
+				c.Errorf("Failed to encode stream value: %s", err.Error())
+				return
+			}
+			if sse {
+				enc.buf.WriteByte('\n')
+			}
+			if _, err := w.Write(enc.buf.Bytes()); err != nil {
+				c.rw.writeFailed(c, err)
+				return
+			}
+			rc.Flush()
+		}
+	}
+}
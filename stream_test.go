@@ -0,0 +1,106 @@
+package iorest
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamTrailer(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("events", func(c *Context) (interface{}, error) {
+		c.SetTrailer("X-Stream-Status")
+		ch := make(chan interface{})
+		go func() {
+			defer close(ch)
+			for i := 0; i < 3; i++ {
+				ch <- map[string]int{"n": i}
+			}
+			c.WriteTrailer("X-Stream-Status", "complete")
+		}()
+		return ch, nil
+	})
+	ts := httptest.NewServer(s.Mux)
+	defer ts.Close()
+	res, err := http.Get(ts.URL + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "{\"n\":0}\n{\"n\":1}\n{\"n\":2}\n" {
+		t.Errorf("body = %q", body)
+	}
+	if got := res.Trailer.Get("X-Stream-Status"); got != "complete" {
+		t.Errorf("trailer = %q, want complete", got)
+	}
+}
+
+func TestStreamChannel(t *testing.T) {
+	s := newTestServer()
+	s.HandleFunc("numbers", func(c *Context) (interface{}, error) {
+		ch := make(chan interface{})
+		go func() {
+			defer close(ch)
+			for i := 1; i <= 3; i++ {
+				ch <- map[string]int{"n": i}
+			}
+		}()
+		var ro <-chan interface{} = ch
+		return ro, nil
+	})
+	res, _ := s.ServeTest("GET", "/api/numbers", nil)
+	if res.Header.Get("Content-Type") != "application/x-ndjson" || string(res.Body) != "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n" {
+		t.Errorf("ndjson: got %q %q", res.Header.Get("Content-Type"), res.Body)
+	}
+	req := httptest.NewRequest("GET", "/api/numbers", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	s.serveHTTP(w, req)
+	if w.Header().Get("Content-Type") != "text/event-stream" || w.Body.String() != "data: {\"n\":1}\n\ndata: {\"n\":2}\n\ndata: {\"n\":3}\n\n" {
+		t.Errorf("sse: got %q %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
+
+func TestStreamChannelCancel(t *testing.T) {
+	s := newTestServer()
+	stopped := make(chan struct{})
+	s.HandleFunc("ticks", func(c *Context) (interface{}, error) {
+		ch := make(chan interface{})
+		ctx := c.Context()
+		go func() {
+			defer close(stopped)
+			for i := 0; ; i++ {
+				select {
+				case ch <- i:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ch, nil
+	})
+	ts := httptest.NewServer(s.Mux)
+	defer ts.Close()
+	res, err := http.Get(ts.URL + "/api/ticks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "0" {
+		t.Fatalf("first value = %q, %v", line, err)
+	}
+	res.Body.Close()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("producer still running after the client went away")
+	}
+}
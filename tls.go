@@ -0,0 +1,44 @@
+package iorest
+
+import (
+	"crypto/tls"
+)
+
+type TLSPreset int
+
+const (
+	TLSDefault TLSPreset = iota
+	TLSIntermediate
+	TLSModern
+)
+
+func (p TLSPreset) Config() *tls.Config {
+	switch p {
+	case TLSIntermediate:
+		return &tls.Config{
+			MinVersion:       tls.VersionTLS12,
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			},
+		}
+	case TLSModern:
+		return &tls.Config{
+			MinVersion:       tls.VersionTLS13,
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		}
+	}
+	return nil
+}
+
+func (s *Server) tlsConfig() *tls.Config {
+	if s.TLSConfig != nil {
+		return s.TLSConfig.Clone()
+	}
+	return s.TLSPreset.Config()
+}
@@ -0,0 +1,32 @@
+package iorest
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestTLSPreset(t *testing.T) {
+	tests := []struct {
+		preset  TLSPreset
+		min     uint16
+		ciphers bool
+	}{
+		{TLSIntermediate, tls.VersionTLS12, true},
+		{TLSModern, tls.VersionTLS13, false},
+	}
+	for _, tt := range tests {
+		s := &Server{TLSPreset: tt.preset}
+		cfg := s.newServer(":0").TLSConfig
+		if cfg == nil || cfg.MinVersion != tt.min || (len(cfg.CipherSuites) > 0) != tt.ciphers {
+			t.Errorf("preset %d: got %+v", tt.preset, cfg)
+		}
+	}
+	if cfg := (&Server{}).newServer(":0").TLSConfig; cfg != nil {
+		t.Errorf("default preset: got %+v, want nil", cfg)
+	}
+	override := &tls.Config{MinVersion: tls.VersionTLS11}
+	s := &Server{TLSPreset: TLSModern, TLSConfig: override}
+	if cfg := s.newServer(":0").TLSConfig; cfg.MinVersion != tls.VersionTLS11 || cfg == override {
+		t.Errorf("TLSConfig override: got %+v", cfg)
+	}
+}
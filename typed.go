@@ -0,0 +1,7 @@
+package iorest
+
+func TypedHandler[T any](fn func(c *Context, state *T) (interface{}, error)) Handler {
+	return func(c *Context) (interface{}, error) {
+		return fn(c, new(T))
+	}
+}
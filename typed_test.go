@@ -0,0 +1,34 @@
+package iorest
+
+import (
+	"net/http"
+	"testing"
+)
+
+type txState struct {
+	User    string
+	Queries []string
+}
+
+func TestTypedHandler(t *testing.T) {
+	s := newTestServer()
+	var states []*txState
+	s.HandleFunc("orders", TypedHandler(func(c *Context, tx *txState) (interface{}, error) {
+		if tx.User != "" || len(tx.Queries) != 0 {
+			t.Errorf("state not fresh: %+v", tx)
+		}
+		tx.User = c.FormValue("user", "")
+		tx.Queries = append(tx.Queries, "SELECT 1")
+		states = append(states, tx)
+		return tx, nil
+	}))
+	for _, user := range []string{"ann", "bob"} {
+		res, _ := s.ServeTest("GET", "/api/orders?user="+user, nil)
+		if want := `{"User":"` + user + `","Queries":["SELECT 1"]}` + "\n"; res.Code != http.StatusOK || string(res.Body) != want {
+			t.Errorf("%s: got %d %q", user, res.Code, res.Body)
+		}
+	}
+	if len(states) != 2 || states[0] == states[1] {
+		t.Errorf("state shared across requests: %v", states)
+	}
+}
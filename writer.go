@@ -0,0 +1,63 @@
+package iorest
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+var errResponseTooLarge = errors.New("Response exceeds MaxResponseBytes")
+
+type responseWriter struct {
+	http.ResponseWriter
+	limit  int64
+	size   int64
+	status int
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.limit > 0 && w.size+int64(len(p)) > w.limit {
+		return 0, errResponseTooLarge
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseWriter) abortTooLarge(c *Context) {
+	c.Errorf("Response exceeds %d bytes, aborted after %d bytes", w.limit, w.size)
+	if w.status == 0 {
+		http.Error(w.ResponseWriter, "Response too large", http.StatusInternalServerError)
+	}
+}
+
+func (w *responseWriter) writeFailed(c *Context, err error) {
+	switch {
+	case err == errResponseTooLarge:
+		w.abortTooLarge(c)
+	case isClientGone(err) || c.request.Context().Err() != nil:
+		c.Debugf("Client gone after %d bytes: %s", w.size, err.Error())
+		c.count("client_gone")
+	default:
+		c.Errorf("Failed to write response after %d bytes: %s", w.size, err.Error())
+	}
+}
+
+func isClientGone(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,176 @@
+package iorest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestMaxResponseBytes(t *testing.T) {
+	s := newTestServer()
+	s.MaxResponseBytes = 1024
+	s.HandleFunc("small", func(c *Context) (interface{}, error) {
+		return strings.Repeat("x", 100), nil
+	})
+	s.HandleFunc("huge", func(c *Context) (interface{}, error) {
+		return strings.Repeat("x", 10000), nil
+	})
+	s.HandleFunc("stream", func(c *Context) (interface{}, error) {
+		c.SetResourceType("application/octet-stream")
+		return strings.NewReader(strings.Repeat("x", 10000)), nil
+	})
+	res, err := s.ServeTest("GET", "/api/small", nil)
+	if err != nil || res.Code != http.StatusOK {
+		t.Fatalf("Small response gave %d %v", res.Code, err)
+	}
+	res, _ = s.ServeTest("GET", "/api/huge", nil)
+	if res.Code != http.StatusInternalServerError || len(res.Body) > 1024 {
+		t.Errorf("Over-limit response gave %d with %d bytes", res.Code, len(res.Body))
+	}
+	res, _ = s.ServeTest("GET", "/api/stream", nil)
+	if len(res.Body) > 1024 {
+		t.Errorf("Over-limit stream wrote %d bytes", len(res.Body))
+	}
+}
+
+type faultyWriter struct {
+	header    http.Header
+	code      int
+	body      bytes.Buffer
+	failAfter int
+	chunk     int
+	writes    int
+	failed    bool
+	afterFail int
+}
+
+func newFaultyWriter(failAfter, chunk int) *faultyWriter {
+	return &faultyWriter{header: make(http.Header), failAfter: failAfter, chunk: chunk}
+}
+
+func (w *faultyWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *faultyWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *faultyWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if w.failed {
+		w.afterFail++
+		return 0, syscall.EPIPE
+	}
+	if w.chunk > 0 && len(p) > w.chunk {
+		p = p[:w.chunk]
+	}
+	if w.failAfter >= 0 && w.body.Len()+len(p) > w.failAfter {
+		n, _ := w.body.Write(p[:w.failAfter-w.body.Len()])
+		w.failed = true
+		return n, syscall.EPIPE
+	}
+	return w.body.Write(p)
+}
+
+func TestBrokenPipe(t *testing.T) {
+	s := newTestServer()
+	logger := &captureLogger{}
+	s.Logger = logger
+	s.HandleFunc("bytes", func(c *Context) (interface{}, error) {
+		c.SetResourceType("application/octet-stream")
+		return bytes.Repeat([]byte("x"), 1000), nil
+	})
+	w := newFaultyWriter(100, 0)
+	s.serveHTTP(w, httptest.NewRequest("GET", "/api/bytes", nil))
+	if w.afterFail != 0 {
+		t.Errorf("Wrote %d more times after the client went away", w.afterFail)
+	}
+	if w.code != http.StatusOK || w.body.Len() != 100 {
+		t.Errorf("Got %d with %d bytes", w.code, w.body.Len())
+	}
+	if lines := logger.output(); len(lines) != 0 {
+		t.Errorf("Broken pipe was logged outside debug mode: %q", lines)
+	}
+}
+
+func TestShortWrites(t *testing.T) {
+	s := newTestServer()
+	body := make([]byte, 10000)
+	for i := range body {
+		body[i] = byte('a' + i%26)
+	}
+	s.HandleFunc("bytes", func(c *Context) (interface{}, error) {
+		c.SetResourceType("application/octet-stream")
+		return body, nil
+	})
+	w := newFaultyWriter(-1, 7)
+	s.serveHTTP(w, httptest.NewRequest("GET", "/api/bytes", nil))
+	if !bytes.Equal(w.body.Bytes(), body) {
+		t.Errorf("Short writes delivered %d bytes, want the %d byte body exactly once", w.body.Len(), len(body))
+	}
+	if w.writes < len(body)/7 {
+		t.Errorf("Expected at least %d writes, got %d", len(body)/7, w.writes)
+	}
+}
+
+type cancelWriter struct {
+	*faultyWriter
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	n, err := w.faultyWriter.Write(p)
+	if err != nil {
+		w.cancel()
+		err = errors.New("write: connection closed")
+	}
+	return n, err
+}
+
+func TestClientGoneMidEncode(t *testing.T) {
+	s := newTestServer()
+	logger := &captureLogger{}
+	s.Logger = logger
+	m := &fakeMetrics{}
+	s.Metrics = m
+	rows := make([]map[string]string, 1000)
+	for i := range rows {
+		rows[i] = map[string]string{"name": "row", "value": strings.Repeat("v", 20)}
+	}
+	s.HandleFunc("rows", func(c *Context) (interface{}, error) {
+		return rows, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &cancelWriter{faultyWriter: newFaultyWriter(512, 128), cancel: cancel}
+	s.serveHTTP(w, httptest.NewRequest("GET", "/api/rows", nil).WithContext(ctx))
+	if w.afterFail != 0 || w.code != http.StatusOK || w.body.Len() != 512 {
+		t.Errorf("got %d with %d bytes, %d writes after the failure", w.code, w.body.Len(), w.afterFail)
+	}
+	if got := m.count("client_gone.rows"); got != 1 {
+		t.Errorf("client_gone = %d, want 1", got)
+	}
+	if lines := logger.output(); len(lines) != 0 {
+		t.Errorf("client disconnect logged as an error: %q", lines)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	s.HandleFunc("slow", func(c *Context) (interface{}, error) {
+		cancel()
+		return rows, nil
+	})
+	fw := newFaultyWriter(-1, 0)
+	s.serveHTTP(fw, httptest.NewRequest("GET", "/api/slow", nil).WithContext(ctx))
+	if fw.writes != 0 || m.count("client_gone.slow") != 1 {
+		t.Errorf("gone before write: %d writes, client_gone %d", fw.writes, m.count("client_gone.slow"))
+	}
+}